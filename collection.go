@@ -19,8 +19,9 @@ import (
 // (struct나 interface 로 감싸도 되지만 어차피 개발자가 직접 확인해야 해서 직접 타입 추론하는 것보다 간단하거나 안전하지 않음)
 
 type Collection[T any] struct {
-	items []any
-	err   error
+	items  []any
+	err    error
+	stages int // number of Map/MapWithError/Filter calls so far
 }
 
 func From[From, To any](from []From) Collection[To] {
@@ -44,7 +45,8 @@ func (col Collection[T]) Map(fn func(any) any) Collection[T] {
 	}
 
 	return Collection[T]{
-		items: out,
+		items:  out,
+		stages: col.stages + 1,
 	}
 }
 func (col Collection[T]) MapWithError(fn func(any) (any, error)) Collection[T] {
@@ -53,18 +55,19 @@ func (col Collection[T]) MapWithError(fn func(any) (any, error)) Collection[T] {
 	}
 
 	out := make([]any, 0, len(col.items))
-	for _, v := range col.items {
+	for i, v := range col.items {
 		r, err := fn(v)
 		if err != nil {
 			return Collection[T]{
-				err: err,
+				err: &StageError{StageIndex: col.stages, Index: i, Err: err},
 			}
 		}
 		out = append(out, r)
 	}
 
 	return Collection[T]{
-		items: out,
+		items:  out,
+		stages: col.stages + 1,
 	}
 }
 
@@ -81,7 +84,8 @@ func (col Collection[T]) Filter(fn func(any) bool) Collection[T] {
 	}
 
 	return Collection[T]{
-		items: out,
+		items:  out,
+		stages: col.stages + 1,
 	}
 }
 
@@ -90,15 +94,13 @@ func (c Collection[T]) ToSlice() ([]T, error) {
 	if c.err != nil {
 		return nil, c.err
 	}
-	arr, err := SliceMapWithError(c.items, func(v any) (T, error) {
-		a, ok := v.(T)
-		if !ok {
-			return a, fmt.Errorf("type convert failed: expected %T, got %T", *new(T), v)
+	arr := make([]T, 0, len(c.items))
+	for i, item := range c.items {
+		v, err := as[T](item)
+		if err != nil {
+			return nil, locate(err, i)
 		}
-		return a, nil
-	})
-	if err != nil {
-		return nil, err
+		arr = append(arr, v)
 	}
 	return arr, nil
 }
@@ -120,8 +122,12 @@ func (c Collection[T]) ForEach(fn func(item T) error) error {
 		return c.err
 	}
 
-	for _, item := range c.items {
-		if err := fn(item.(T)); err != nil {
+	for i, item := range c.items {
+		v, err := as[T](item)
+		if err != nil {
+			return locate(err, i)
+		}
+		if err := fn(v); err != nil {
 			return err
 		}
 	}
@@ -142,7 +148,11 @@ func (c Collection[T]) First() (*T, error) {
 		return nil, fmt.Errorf("collection is empty")
 	}
 
-	v := c.items[0].(T)
+	i := 0
+	v, err := as[T](c.items[i])
+	if err != nil {
+		return nil, locate(err, i)
+	}
 
 	return &v, nil
 }
@@ -154,7 +164,11 @@ func (c Collection[T]) Last() (*T, error) {
 		return nil, fmt.Errorf("collection is empty")
 	}
 
-	v := c.items[len(c.items)-1].(T)
+	i := len(c.items) - 1
+	v, err := as[T](c.items[i])
+	if err != nil {
+		return nil, locate(err, i)
+	}
 
 	return &v, nil
 }
@@ -166,7 +180,19 @@ func (c Collection[T]) Pick(rand int) (*T, error) {
 		return nil, fmt.Errorf("collection is empty")
 	}
 
-	v := c.items[rand%len(c.items)].(T)
+	i := rand % len(c.items)
+	v, err := as[T](c.items[i])
+	if err != nil {
+		return nil, locate(err, i)
+	}
 
 	return &v, nil
 }
+
+// locate records the item index in a *TypeMismatchError.
+func locate(err error, index int) error {
+	if tm, ok := err.(*TypeMismatchError); ok {
+		tm.Index = index
+	}
+	return err
+}
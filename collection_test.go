@@ -152,3 +152,41 @@ func TestSum(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, 10, result)
 }
+
+func TestCollection_MapWithError_StageError(t *testing.T) {
+	cause := errors.New("error")
+	_, err := From[int, int]([]int{1, 2, 3}).
+		Filter(func(v any) bool { return true }).
+		MapWithError(func(v any) (any, error) {
+			if v.(int) == 3 {
+				return nil, cause
+			}
+			return v, nil
+		}).
+		ToSlice()
+
+	var se *StageError
+	assert.ErrorAs(t, err, &se)
+	assert.Equal(t, 1, se.StageIndex)
+	assert.Equal(t, 2, se.Index)
+	assert.ErrorIs(t, err, cause)
+}
+
+func TestCollection_TypeMismatch(t *testing.T) {
+	col := From[int, string]([]int{1, 2, 3})
+
+	_, err := col.ToSlice()
+	assert.Equal(t, &TypeMismatchError{Expected: "string", Actual: "int", Stage: -1, Index: 0}, err)
+
+	err = col.ForEach(func(string) error { return nil })
+	assert.Equal(t, &TypeMismatchError{Expected: "string", Actual: "int", Stage: -1, Index: 0}, err)
+
+	_, err = col.First()
+	assert.Equal(t, &TypeMismatchError{Expected: "string", Actual: "int", Stage: -1, Index: 0}, err)
+
+	_, err = col.Last()
+	assert.Equal(t, &TypeMismatchError{Expected: "string", Actual: "int", Stage: -1, Index: 2}, err)
+
+	_, err = col.Pick(4)
+	assert.Equal(t, &TypeMismatchError{Expected: "string", Actual: "int", Stage: -1, Index: 1}, err)
+}
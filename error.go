@@ -0,0 +1,154 @@
+package functional
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// StageError records which stage of a pipeline failed, and on which element.
+// It is returned by Pipe, LazyPipeline.Run/Seq and Collection.MapWithError,
+// and wraps the original error for errors.Is, errors.As and errors.Unwrap.
+type StageError struct {
+	Stage      string // stage name given with Named/NamedBarrier/NamedPipe, or ""
+	StageIndex int    // position of the stage in the pipeline, or -1 if unknown
+	Index      int    // position of the failing element, or -1 for slice-level failures
+	Err        error
+}
+
+func (e *StageError) Error() string {
+	var b strings.Builder
+	if e.StageIndex >= 0 {
+		fmt.Fprintf(&b, "stage %d", e.StageIndex)
+		if e.Stage != "" {
+			fmt.Fprintf(&b, " (%s)", e.Stage)
+		}
+	} else if e.Stage != "" {
+		fmt.Fprintf(&b, "stage %s", e.Stage)
+	}
+	if e.Index >= 0 {
+		if b.Len() > 0 {
+			b.WriteString(", ")
+		}
+		fmt.Fprintf(&b, "index %d", e.Index)
+	}
+	if b.Len() > 0 {
+		b.WriteString(": ")
+	}
+	b.WriteString(e.Err.Error())
+	return b.String()
+}
+
+func (e *StageError) Unwrap() error {
+	return e.Err
+}
+
+// stageError attributes err to the given stage. A *StageError returned by
+// the stage itself (e.g. MapWithError reporting the failing element) is
+// completed in place rather than wrapped again.
+func stageError(err error, name string, stageIndex, index int) *StageError {
+	se, ok := err.(*StageError)
+	if ok && se.StageIndex < 0 {
+		se.StageIndex = stageIndex
+		if se.Stage == "" {
+			se.Stage = name
+		}
+	} else {
+		se = &StageError{Stage: name, StageIndex: stageIndex, Index: index, Err: err}
+	}
+
+	// Locate a type mismatch, panic or timeout the stage could not locate itself
+	var tm *TypeMismatchError
+	if errors.As(se.Err, &tm) && tm.Stage < 0 {
+		tm.Stage = se.StageIndex
+		tm.Index = se.Index
+	}
+	var pe *PanicError
+	if errors.As(se.Err, &pe) && pe.Stage < 0 {
+		pe.Stage = se.StageIndex
+		pe.Index = se.Index
+	}
+	var te *TimeoutError
+	if errors.As(se.Err, &te) && te.Stage < 0 {
+		te.Stage = se.StageIndex
+		te.Index = se.Index
+	}
+	return se
+}
+
+// TypeMismatchError is returned instead of panicking when an element
+// (or a slice) does not have the type a stage expects, typically because
+// the type parameters of consecutive stages do not line up.
+type TypeMismatchError struct {
+	Expected string // expected type, e.g. "int"
+	Actual   string // actual type, e.g. "string"
+	Stage    int    // position of the stage in the pipeline, or -1 if unknown
+	Index    int    // position of the element, or -1 if unknown or slice-level
+}
+
+func (e *TypeMismatchError) Error() string {
+	return fmt.Sprintf("type mismatch: expected %s, got %s", e.Expected, e.Actual)
+}
+
+// as asserts v to T, returning a *TypeMismatchError instead of panicking.
+func as[T any](v any) (T, error) {
+	t, ok := v.(T)
+	if !ok {
+		return t, &TypeMismatchError{
+			Expected: reflect.TypeFor[T]().String(),
+			Actual:   fmt.Sprintf("%T", v),
+			Stage:    -1,
+			Index:    -1,
+		}
+	}
+	return t, nil
+}
+
+// PanicError is returned instead of crashing when a user function panics
+// and panic recovery is enabled, with WithPanicRecovery for a LazyPipeline
+// or Recover for a PipeFn.
+type PanicError struct {
+	Value any    // value passed to panic
+	Stack []byte // stack trace of the panicking goroutine
+	Stage int    // position of the stage in the pipeline, or -1 if unknown
+	Index int    // position of the element, or -1 if unknown or slice-level
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// Unwrap returns the panic value if it is an error.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// recovered converts the result of recover into a *PanicError, or nil.
+func recovered(r any) error {
+	if r == nil {
+		return nil
+	}
+	return &PanicError{Value: r, Stack: debug.Stack(), Stage: -1, Index: -1}
+}
+
+// TimeoutError is returned when an element takes longer than the limit set
+// with WithElementTimeout or LazyTimeout. It matches
+// context.DeadlineExceeded with errors.Is.
+type TimeoutError struct {
+	Timeout time.Duration // the exceeded limit
+	Stage   int           // position of the stage in the pipeline, or -1 if unknown
+	Index   int           // position of the element, or -1 if unknown
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("timed out after %s", e.Timeout)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return context.DeadlineExceeded
+}
@@ -0,0 +1,53 @@
+package functional
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStageError_Error(t *testing.T) {
+	cause := errors.New("boom")
+
+	assert.EqualError(t, &StageError{Stage: "parse", StageIndex: 2, Index: 5, Err: cause}, "stage 2 (parse), index 5: boom")
+	assert.EqualError(t, &StageError{StageIndex: 2, Index: 5, Err: cause}, "stage 2, index 5: boom")
+	assert.EqualError(t, &StageError{StageIndex: 2, Index: -1, Err: cause}, "stage 2: boom")
+	assert.EqualError(t, &StageError{Stage: "parse", StageIndex: -1, Index: -1, Err: cause}, "stage parse: boom")
+	assert.EqualError(t, &StageError{StageIndex: -1, Index: 5, Err: cause}, "index 5: boom")
+}
+
+func TestStageError_Unwrap(t *testing.T) {
+	cause := errors.New("boom")
+	var err error = &StageError{StageIndex: 0, Index: 0, Err: cause}
+
+	assert.ErrorIs(t, err, cause)
+	assert.Equal(t, cause, errors.Unwrap(err))
+}
+
+func TestAs(t *testing.T) {
+	v, err := as[int](1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, v)
+
+	_, err = as[int]("1")
+	assert.Equal(t, &TypeMismatchError{Expected: "int", Actual: "string", Stage: -1, Index: -1}, err)
+	assert.EqualError(t, err, "type mismatch: expected int, got string")
+
+	_, err = as[fmt.Stringer](nil)
+	assert.EqualError(t, err, "type mismatch: expected fmt.Stringer, got <nil>")
+}
+
+func TestPanicError(t *testing.T) {
+	cause := errors.New("boom")
+	err := recovered(cause)
+	assert.EqualError(t, err, "panic: boom")
+	assert.ErrorIs(t, err, cause)
+
+	err = recovered("plain")
+	assert.EqualError(t, err, "panic: plain")
+	assert.Nil(t, errors.Unwrap(err))
+
+	assert.Nil(t, recovered(nil))
+}
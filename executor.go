@@ -0,0 +1,72 @@
+package functional
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// Executor runs the work of parallel segments on behalf of a LazyPipeline.
+// Register it with WithExecutor. Sharing one Executor between pipelines
+// bounds the work they do at once, whatever their WithWorkers.
+type Executor interface {
+	// Run calls task and returns once it has returned. It may wait for
+	// capacity first, and returns ctx.Err() without calling task if ctx is
+	// done before then. task never blocks on other tasks.
+	Run(ctx context.Context, task func()) error
+}
+
+// Pool is a bounded Executor: at most size tasks run at once, the others
+// wait in Run. It is safe for concurrent use and meant to be shared, e.g.
+// one Pool of runtime.NumCPU() for every pipeline of a server.
+type Pool struct {
+	sem       chan struct{}
+	active    atomic.Int64
+	queued    atomic.Int64
+	completed atomic.Int64
+}
+
+// PoolStats is a snapshot of the activity of a Pool.
+type PoolStats struct {
+	Size      int   // maximum number of tasks running at once
+	Active    int   // tasks running
+	Queued    int   // tasks waiting for capacity
+	Completed int64 // tasks finished since the pool was created
+}
+
+// NewPool returns a Pool running at most size tasks at once.
+// Values below 1 are treated as 1.
+func NewPool(size int) *Pool {
+	return &Pool{sem: make(chan struct{}, max(size, 1))}
+}
+
+// Run calls task on the calling goroutine once fewer than size tasks are
+// running. It implements Executor.
+func (p *Pool) Run(ctx context.Context, task func()) error {
+	p.queued.Add(1)
+	select {
+	case p.sem <- struct{}{}:
+		p.queued.Add(-1)
+	case <-ctx.Done():
+		p.queued.Add(-1)
+		return ctx.Err()
+	}
+
+	p.active.Add(1)
+	defer func() {
+		p.active.Add(-1)
+		p.completed.Add(1)
+		<-p.sem
+	}()
+	task()
+	return nil
+}
+
+// Stats returns the current activity of the pool.
+func (p *Pool) Stats() PoolStats {
+	return PoolStats{
+		Size:      cap(p.sem),
+		Active:    int(p.active.Load()),
+		Queued:    int(p.queued.Load()),
+		Completed: p.completed.Load(),
+	}
+}
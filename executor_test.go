@@ -0,0 +1,58 @@
+package functional
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPool_Stats(t *testing.T) {
+	pool := NewPool(1)
+	assert.Equal(t, PoolStats{Size: 1}, pool.Stats())
+
+	release := make(chan struct{})
+	go pool.Run(context.Background(), func() { <-release })
+	assert.Eventually(t, func() bool { return pool.Stats().Active == 1 }, time.Second, time.Millisecond)
+
+	done := make(chan error)
+	go func() { done <- pool.Run(context.Background(), func() {}) }()
+	assert.Eventually(t, func() bool { return pool.Stats().Queued == 1 }, time.Second, time.Millisecond)
+
+	close(release)
+	assert.NoError(t, <-done)
+	assert.Equal(t, PoolStats{Size: 1, Completed: 2}, pool.Stats())
+}
+
+func TestPool_Cancel(t *testing.T) {
+	pool := NewPool(1)
+	release := make(chan struct{})
+	defer close(release)
+	go pool.Run(context.Background(), func() { <-release })
+	assert.Eventually(t, func() bool { return pool.Stats().Active == 1 }, time.Second, time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	called := false
+	err := pool.Run(ctx, func() { called = true })
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.False(t, called)
+	assert.Equal(t, 0, pool.Stats().Queued)
+}
+
+func TestPool_Panic(t *testing.T) {
+	pool := NewPool(1)
+	assert.Panics(t, func() {
+		pool.Run(context.Background(), func() { panic("boom") })
+	})
+	// The slot of the panicking task is released
+	assert.NoError(t, pool.Run(context.Background(), func() {}))
+	assert.Equal(t, PoolStats{Size: 1, Completed: 2}, pool.Stats())
+}
+
+func TestNewPool_Size(t *testing.T) {
+	assert.Equal(t, 1, NewPool(0).Stats().Size)
+	assert.Equal(t, 4, NewPool(4).Stats().Size)
+}
@@ -1,12 +1,21 @@
 package functional
 
-import "fmt"
+import (
+	"context"
+	"iter"
+	"reflect"
+	"slices"
+	"time"
+)
 
 // BarrierFn wraps a PipeFn with type-safe []any ↔ []T converters,
 // capturing type information at construction time via generics
 // so that no reflect is needed at execution time.
 type BarrierFn struct {
-	run func([]any) ([]any, error)
+	name      string
+	run       barrierFn
+	chunkable bool
+	in, out   reflect.Type // element types, checked by LazyPipeline.Validate
 }
 
 // Barrier wraps a PipeFn for use in a LazyPipeline.
@@ -14,76 +23,275 @@ type BarrierFn struct {
 // enabling type-safe conversion between []any and typed slices
 // without reflect.
 func Barrier[In, Out any](fn PipeFn) BarrierFn {
+	return BarrierCtx[In, Out](func(_ context.Context, input any) (any, error) {
+		return fn(input)
+	})
+}
+
+// BarrierCtx is Barrier for a PipeFnCtx, which receives the pipeline
+// context set with WithContext, e.g. RetryCtx.
+func BarrierCtx[In, Out any](fn PipeFnCtx) BarrierFn {
 	return BarrierFn{
-		run: func(items []any) ([]any, error) {
+		run: func(ctx context.Context, items []any) ([]any, error) {
 			// []any → []In
 			typed := make([]In, len(items))
 			for i, v := range items {
-				typed[i] = v.(In)
+				t, err := as[In](v)
+				if err != nil {
+					return nil, &StageError{StageIndex: -1, Index: i, Err: err}
+				}
+				typed[i] = t
 			}
 
 			// Run PipeFn
-			result, err := fn(typed)
+			result, err := fn(ctx, typed)
 			if err != nil {
 				return nil, err
 			}
 
 			// []Out → []any
-			outSlice := result.([]Out)
+			outSlice, err := as[[]Out](result)
+			if err != nil {
+				return nil, err
+			}
 			out := make([]any, len(outSlice))
 			for i, v := range outSlice {
 				out[i] = v
 			}
 			return out, nil
 		},
+		in:  reflect.TypeFor[In](),
+		out: reflect.TypeFor[Out](),
 	}
 }
 
+// ChunkedBarrier wraps a chunk-safe PipeFn for use in a LazyPipeline.
+// A PipeFn is chunk-safe when running it over consecutive pieces of a slice
+// and concatenating the results is the same as running it over the whole
+// slice, e.g. Map, Filter, MapWithError, Tap and TapWithError.
+//
+// Chunked barriers are split into WithChunkSize pieces (or one piece per
+// worker when the chunk size is 0 and WithWorkers(n) is set) that run on
+// the worker pool. Barriers that need the whole slice, such as sorting or
+// Once, must use Barrier instead.
+func ChunkedBarrier[In, Out any](fn PipeFn) BarrierFn {
+	b := Barrier[In, Out](fn)
+	b.chunkable = true
+	return b
+}
+
+// NamedBarrier gives a barrier a name. The name is reported in StageError,
+// Observer events and Explain output.
+func NamedBarrier(name string, fn BarrierStage) BarrierFn {
+	b := fn.barrier()
+	b.name = name
+	return b
+}
+
+// BarrierStage is a slice-level stage accepted by LazyPipeline.Pipe.
+// It is implemented by BarrierFn and by SliceFn, returned by the built-in
+// barriers (SortBy, GroupBy, Chunk, Reverse, TopK, Shuffle), which can be
+// used without Barrier.
+type BarrierStage interface {
+	barrier() BarrierFn
+}
+
+func (fn BarrierFn) barrier() BarrierFn {
+	return fn
+}
+
+// barrierFn is the erased form of a slice-level stage.
+type barrierFn func(ctx context.Context, items []any) ([]any, error)
+
 // stage represents a single step in a lazy pipeline.
-// Exactly one of elemFn or barrierFn is non-nil.
+// Exactly one of elemFn, flatFn, serialFn, batchFn or barrierFn is non-nil.
 type stage struct {
-	elemFn    ElemFn                      // element-level (fusible)
-	barrierFn func([]any) ([]any, error) // slice-level (barrier)
+	kind      StageKind
+	name      string
+	in, out   reflect.Type // element types; nil when unknown
+	elemFn    ElemFnCtx    // element-level (fusible)
+	flatFn    flatFn       // one-to-many element-level (fusible)
+	serialFn  serialFn     // order-sensitive element-level
+	batchFn   *batchFn     // batching element-level (fusible)
+	workers   int          // worker count set with Concurrency; 0 = WithWorkers
+	through   bool         // passes elements through, skipped by Validate
+	err       error        // the stage cannot run, e.g. LazyRetry of LazyTake
+	barrierFn barrierFn    // slice-level (barrier)
+	chunkable bool         // barrierFn may run on chunks
 }
 
 // segment is a group of consecutive ElemFn stages that can be fused
 // into a single loop, or a single barrier.
 type segment struct {
-	elemFns   []ElemFn                    // non-empty for fusible segments
-	barrierFn func([]any) ([]any, error) // non-nil for barrier segments
+	index     int         // position of the segment in the pipeline
+	first     int         // index of the first stage in the pipeline
+	kind      StageKind   // kind of the barrier stage
+	names     []string    // stage names, one per stage
+	elemFns   []ElemFnCtx // non-empty for fusible segments
+	flatFns   []flatFn    // one per elemFns; non-nil replaces elemFns[i]
+	serialFns []serialFn  // one per elemFns, all non-nil in serial segments
+	batchFns  []*batchFn  // one per elemFns; non-nil replaces elemFns[i]
+	batching  bool        // some batchFns are non-nil
+	conc      int         // worker count set with Concurrency; 0 = WithWorkers
+	serial    bool        // order-sensitive stages, always sequential
+	barrierFn barrierFn   // non-nil for barrier segments
+	chunkable bool        // barrierFn may run on chunks
+
+	counters []stageCounters // per-stage statistics, only with an Observer
+}
+
+// stream is a push-style sequence of elements flowing between segments.
+// Elements are produced one at a time, together with their position in
+// the pipeline input (or in the output of the last barrier);
+// only barriers materialize them.
+type stream struct {
+	n    int // number of elements, or -1 when unknown
+	max  int // upper bound on n, or -1 when unbounded
+	each func(ctx context.Context, yield func(index int, v any) bool) error
+	at   func(i int) any // element at index i of a materialized input, or nil
+}
+
+// sliceStream returns a stream over an already materialized slice.
+func sliceStream(items []any) stream {
+	return stream{
+		n:   len(items),
+		max: len(items),
+		each: func(ctx context.Context, yield func(int, any) bool) error {
+			for i, v := range items {
+				if !yield(i, v) {
+					return nil
+				}
+			}
+			return nil
+		},
+		at: func(i int) any { return items[i] },
+	}
+}
+
+// collect materializes a stream into a slice.
+func (s stream) collect(ctx context.Context) ([]any, error) {
+	items := make([]any, 0, max(s.n, 0))
+	err := s.each(ctx, func(_ int, v any) bool {
+		items = append(items, v)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
 }
 
 // LazyPipeline is a deferred execution pipeline that collects stages
 // and executes them on Run(). Consecutive element-level stages are fused
 // into a single loop to avoid intermediate slice allocations.
 type LazyPipeline[In, Out any] struct {
-	input  []In
-	stages []stage
+	source   stream
+	stages   []stage
+	segments []segment // precomputed by Compile; nil = built on every run
 }
 
 // Lazy creates a new lazy pipeline with the given input slice.
 func Lazy[In, Out any](input []In) *LazyPipeline[In, Out] {
+	return &LazyPipeline[In, Out]{source: inputStream(input)}
+}
+
+// inputStream returns a stream over the elements of input.
+func inputStream[In any](input []In) stream {
+	return stream{
+		n:   len(input),
+		max: len(input),
+		each: func(ctx context.Context, yield func(int, any) bool) error {
+			for i, v := range input {
+				if !yield(i, v) {
+					return nil
+				}
+			}
+			return nil
+		},
+		at: func(i int) any { return input[i] },
+	}
+}
+
+// LazyFromSeq creates a new lazy pipeline that pulls its input from seq.
+// Elements are consumed one at a time as the fused segments request them,
+// so the input never has to fit in memory unless a barrier materializes it.
+//
+// Since the input length is unknown, the first segment runs in parallel
+// whenever WithWorkers(n) is set, regardless of WithParallelThreshold.
+func LazyFromSeq[In, Out any](seq iter.Seq[In]) *LazyPipeline[In, Out] {
+	return &LazyPipeline[In, Out]{
+		source: stream{
+			n:   -1,
+			max: -1,
+			each: func(ctx context.Context, yield func(int, any) bool) error {
+				i := 0
+				for v := range seq {
+					if !yield(i, v) {
+						return nil
+					}
+					i++
+				}
+				return nil
+			},
+		},
+	}
+}
+
+// LazyFromChan creates a new lazy pipeline that receives its input from ch
+// until it is closed. Elements are consumed one at a time as the fused
+// segments request them. Waiting on ch is aborted when the context set by
+// WithContext is cancelled.
+//
+// Since the input length is unknown, the first segment runs in parallel
+// whenever WithWorkers(n) is set, regardless of WithParallelThreshold.
+func LazyFromChan[In, Out any](ch <-chan In) *LazyPipeline[In, Out] {
 	return &LazyPipeline[In, Out]{
-		input: input,
+		source: stream{
+			n:   -1,
+			max: -1,
+			each: func(ctx context.Context, yield func(int, any) bool) error {
+				for i := 0; ; i++ {
+					select {
+					case v, ok := <-ch:
+						if !ok {
+							return nil
+						}
+						if !yield(i, v) {
+							return nil
+						}
+					case <-ctx.Done():
+						return ctx.Err()
+					}
+				}
+			},
+		},
 	}
 }
 
 // Elem appends element-level transformation stages to the pipeline.
 // Consecutive Elem stages are fused into a single loop during execution.
+// ElemFns carry no types, so Validate skips them, LazyMap and LazyFilter
+// included; pass the ...Typed forms to Stage to have them checked.
 func (lp *LazyPipeline[In, Out]) Elem(fns ...ElemFn) *LazyPipeline[In, Out] {
-	for _, fn := range fns {
-		lp.stages = append(lp.stages, stage{elemFn: fn})
-	}
+	lp.stages = appendElem(lp.stages, fns)
+	return lp
+}
+
+// Stage appends element-level stages of any kind to the pipeline: ElemFn,
+// ElemFnCtx and the ElemStages built by LazyMapCtx, LazyFlatMap, LazyTake,
+// LazyMapTyped and the like. They are fused with neighbouring Elem stages
+// whenever their kind allows it.
+func (lp *LazyPipeline[In, Out]) Stage(fns ...ElemStage) *LazyPipeline[In, Out] {
+	lp.stages = appendElem(lp.stages, fns)
 	return lp
 }
 
 // Pipe appends slice-level transformation stages (barriers) to the pipeline.
 // Each barrier forces materialization of preceding element-level stages.
-// Use Barrier[In, Out](pipeFn) to wrap an existing PipeFn.
-func (lp *LazyPipeline[In, Out]) Pipe(fns ...BarrierFn) *LazyPipeline[In, Out] {
-	for _, fn := range fns {
-		lp.stages = append(lp.stages, stage{barrierFn: fn.run})
-	}
+// Use Barrier[In, Out](pipeFn) to wrap an existing PipeFn; the built-in
+// barriers such as SortBy and GroupBy can be passed directly.
+func (lp *LazyPipeline[In, Out]) Pipe(fns ...BarrierStage) *LazyPipeline[In, Out] {
+	lp.stages = appendPipe(lp.stages, fns)
 	return lp
 }
 
@@ -92,117 +300,518 @@ func (lp *LazyPipeline[In, Out]) Pipe(fns ...BarrierFn) *LazyPipeline[In, Out] {
 // Useful for side-effects (DB queries, logging) whose results
 // are captured via closures for subsequent stages.
 func (lp *LazyPipeline[In, Out]) Once(fn func() error) *LazyPipeline[In, Out] {
-	lp.stages = append(lp.stages, stage{
-		barrierFn: func(items []any) ([]any, error) {
+	lp.stages = appendOnce(lp.stages, fn)
+	return lp
+}
+
+// appendElem appends a fusible stage for every fn to stages.
+func appendElem[S ElemStage](stages []stage, fns []S) []stage {
+	for _, fn := range fns {
+		for _, spec := range fn.spec().stages() {
+			stages = append(stages, stage{name: spec.name, in: spec.in, out: spec.out, elemFn: spec.fn, flatFn: spec.flat, serialFn: spec.serial, batchFn: spec.batch, workers: spec.workers, through: spec.through, err: spec.err})
+		}
+	}
+	return stages
+}
+
+// appendPipe appends a barrier stage for every fn to stages.
+func appendPipe(stages []stage, fns []BarrierStage) []stage {
+	for _, b := range fns {
+		fn := b.barrier()
+		kind := StagePipe
+		if fn.chunkable {
+			kind = StageChunkedPipe
+		}
+		stages = append(stages, stage{kind: kind, name: fn.name, in: fn.in, out: fn.out, barrierFn: fn.run, chunkable: fn.chunkable})
+	}
+	return stages
+}
+
+// appendOnce appends a barrier stage that calls fn once to stages.
+func appendOnce(stages []stage, fn func() error) []stage {
+	return append(stages, stage{
+		kind: StageOnce,
+		barrierFn: func(_ context.Context, items []any) ([]any, error) {
 			if err := fn(); err != nil {
 				return nil, err
 			}
 			return items, nil
 		},
 	})
-	return lp
 }
 
 // Run executes the lazy pipeline and returns the final result.
+//
+// With WithErrorMode(CollectAll), failed elements are dropped and Run
+// returns the remaining results together with the joined element errors.
 func (lp *LazyPipeline[In, Out]) Run(opts ...LazyOption) ([]Out, error) {
-	cfg := defaultConfig()
-	for _, opt := range opts {
-		opt(cfg)
+	cfg := newConfig(opts)
+
+	result := make([]Out, 0, max(lp.source.n, 0))
+	err := lp.each(cfg, func(v Out) bool {
+		result = append(result, v)
+		return true
+	})
+	if err != nil {
+		return nil, err
 	}
+	return result, cfg.elemErrs.err()
+}
 
-	segments := buildSegments(lp.stages)
+// Seq executes the lazy pipeline and yields results one at a time as they
+// leave the last segment. If the pipeline fails, the error is yielded once
+// (with the zero Out) as the final pair.
+//
+// Breaking out of the loop stops all upstream work, including parallel
+// workers. Barriers still materialize everything before them.
+//
+//	for v, err := range pipeline.Seq(opts...) {
+//		if err != nil {
+//			return err
+//		}
+//		...
+//	}
+func (lp *LazyPipeline[In, Out]) Seq(opts ...LazyOption) iter.Seq2[Out, error] {
+	return func(yield func(Out, error) bool) {
+		cfg := newConfig(opts)
 
-	// Convert input to []any
-	items := make([]any, len(lp.input))
-	for i, v := range lp.input {
-		items[i] = v
+		stopped := false
+		err := lp.each(cfg, func(v Out) bool {
+			if !yield(v, nil) {
+				stopped = true
+				return false
+			}
+			return true
+		})
+		if stopped {
+			return
+		}
+		if err == nil {
+			err = cfg.elemErrs.err()
+		}
+		if err != nil {
+			yield(*new(Out), err)
+		}
 	}
+}
 
-	// Execute each segment
-	var err error
-	for _, seg := range segments {
-		if seg.barrierFn != nil {
-			items, err = seg.barrierFn(items)
-		} else {
-			items, err = seg.execute(items, cfg)
+// Validate checks, without running the pipeline, that the element types
+// recorded by the typed stage constructors (LazyMapTyped, LazyFilterTyped,
+// ..., Barrier) line up from In through every stage to Out.
+// Stages whose types are unknown (any ElemFn or ElemFnCtx, including those
+// returned by LazyMap, LazyFilter and the other untyped constructors) are
+// skipped, as is the check right after them. Mismatches are reported as a
+// *StageError wrapping a *TypeMismatchError, attributed to the last typed
+// stage when it is Out that does not match.
+func (lp *LazyPipeline[In, Out]) Validate() error {
+	current := reflect.TypeFor[In]()
+	last, name := -1, "" // stage current comes from, -1 for In
+	for i, st := range lp.stages {
+		if st.err != nil {
+			return stageError(st.err, st.name, i, -1)
+		}
+		if st.kind == StageOnce || st.through {
+			continue
+		}
+		if !assignable(current, st.in) {
+			return mismatch(st.in, current, st.name, i)
 		}
+		current = st.out
+		last, name = i, st.name
+	}
+	if out := reflect.TypeFor[Out](); !assignable(current, out) {
+		// Out does not match what the last typed stage produces
+		return mismatch(out, current, name, last)
+	}
+	return nil
+}
+
+// mismatch returns the *StageError reported by Validate when stage expects
+// elements of type expected but gets actual.
+func mismatch(expected, actual reflect.Type, name string, stage int) error {
+	return &StageError{Stage: name, StageIndex: stage, Index: -1, Err: &TypeMismatchError{
+		Expected: expected.String(),
+		Actual:   actual.String(),
+		Stage:    stage,
+		Index:    -1,
+	}}
+}
+
+// assignable reports whether elements of type from may be passed where to
+// is expected. Unknown (nil) types and interface-typed from, whose dynamic
+// type is only known at run time, are accepted.
+func assignable(from, to reflect.Type) bool {
+	if from == nil || to == nil || from.Kind() == reflect.Interface {
+		return true
+	}
+	return from.AssignableTo(to)
+}
+
+// each executes the lazy pipeline, pulling every element through the chain
+// and passing it to yield converted to Out.
+func (lp *LazyPipeline[In, Out]) each(cfg *lazyConfig, yield func(Out) bool) error {
+	if cfg.validate {
+		if err := lp.Validate(); err != nil {
+			return err
+		}
+	}
+	s, err := lp.execute(cfg)
+	if err != nil {
+		return err
+	}
+
+	index := 0
+	var convErr error
+	err = s.each(cfg.ctx, func(_ int, v any) bool {
+		out, err := as[Out](v)
 		if err != nil {
-			return nil, err
+			convErr = locate(err, index)
+			return false
 		}
+		index++
+		return yield(out)
+	})
+	if convErr != nil {
+		return convErr
 	}
+	return err
+}
 
-	// Convert []any to []Out
-	result := make([]Out, len(items))
-	for i, v := range items {
-		out, ok := v.(Out)
-		if !ok {
-			return nil, fmt.Errorf("Lazy: final type assertion failed: expected %T, got %T at index %d", *new(Out), v, i)
+// execute chains every segment onto the source stream.
+// Fusible segments stay lazy, pulling elements one at a time;
+// barriers materialize everything before them and run immediately.
+func (lp *LazyPipeline[In, Out]) execute(cfg *lazyConfig) (stream, error) {
+	for i, st := range lp.stages {
+		if st.err != nil {
+			return stream{}, stageError(st.err, st.name, i, -1)
+		}
+	}
+	segments := lp.segments
+	if segments == nil {
+		segments = buildSegments(lp.stages)
+	}
+	s := lp.source
+	if cfg.recoverPanics {
+		s = recoverStream(s)
+	}
+	// seg is a copy, so per-run state such as observer counters never
+	// leaks into shared precomputed segments
+	for _, seg := range segments {
+		if cfg.recoverPanics {
+			seg = seg.recovering()
+		}
+		if cfg.elementTimeout > 0 {
+			seg = seg.timed()
+		}
+		if seg.barrierFn == nil {
+			s = seg.stream(s, cfg)
+			continue
+		}
+		items, err := s.collect(cfg.ctx)
+		if err != nil {
+			return stream{}, err
+		}
+		if cfg.observer != nil {
+			items, err = seg.observedBarrier(items, cfg)
+		} else {
+			items, err = seg.runBarrier(items, cfg)
 		}
-		result[i] = out
+		if err != nil {
+			return stream{}, stageError(err, seg.names[0], seg.first, -1)
+		}
+		s = sliceStream(items)
 	}
-	return result, nil
+	return s, nil
 }
 
 // buildSegments groups consecutive stages into fusible segments and barriers.
 func buildSegments(stages []stage) []segment {
 	var segments []segment
-	var currentElems []ElemFn
+	var current segment
 
-	for _, s := range stages {
+	for i, s := range stages {
 		if s.barrierFn != nil {
 			// Flush accumulated ElemFns as a segment
-			if len(currentElems) > 0 {
-				segments = append(segments, segment{elemFns: currentElems})
-				currentElems = nil
+			if len(current.elemFns) > 0 {
+				segments = append(segments, current)
+				current = segment{}
 			}
-			segments = append(segments, segment{barrierFn: s.barrierFn})
+			segments = append(segments, segment{
+				first:     i,
+				kind:      s.kind,
+				names:     []string{s.name},
+				barrierFn: s.barrierFn,
+				chunkable: s.chunkable,
+			})
 		} else {
-			currentElems = append(currentElems, s.elemFn)
+			// Order-sensitive stages cannot share a loop with stages
+			// that may run on the worker pool, and stages with their own
+			// Concurrency need a worker pool of their own
+			if serial := s.serialFn != nil; len(current.elemFns) > 0 && (current.serial != serial || current.conc != s.workers) {
+				segments = append(segments, current)
+				current = segment{}
+			}
+			if len(current.elemFns) == 0 {
+				current.first = i
+				current.serial = s.serialFn != nil
+				current.conc = s.workers
+			}
+			current.elemFns = append(current.elemFns, s.elemFn)
+			current.flatFns = append(current.flatFns, s.flatFn)
+			current.serialFns = append(current.serialFns, s.serialFn)
+			current.batchFns = append(current.batchFns, s.batchFn)
+			current.batching = current.batching || s.batchFn != nil
+			current.names = append(current.names, s.name)
 		}
 	}
 
 	// Flush remaining ElemFns
-	if len(currentElems) > 0 {
-		segments = append(segments, segment{elemFns: currentElems})
+	if len(current.elemFns) > 0 {
+		segments = append(segments, current)
 	}
 
+	for i := range segments {
+		segments[i].index = i
+	}
 	return segments
 }
 
-// execute runs a fusible segment (element-level) either sequentially or in parallel.
-func (seg *segment) execute(items []any, cfg *lazyConfig) ([]any, error) {
-	if cfg.workers > 1 && len(items) >= cfg.parallelThreshold {
-		return seg.executeParallel(items, cfg)
+// runBarrier runs a barrier segment over the materialized items.
+// Chunkable barriers are split into chunks that run on the worker pool.
+func (seg *segment) runBarrier(items []any, cfg *lazyConfig) ([]any, error) {
+	if size := seg.chunkSize(len(items), cfg); size > 0 {
+		return seg.executeChunked(items, size, cfg)
+	}
+	return seg.barrierFn(cfg.ctx, items)
+}
+
+// chunkSize returns the chunk size a barrier over n elements is split into,
+// or 0 when it runs once over the whole slice.
+func (seg *segment) chunkSize(n int, cfg *lazyConfig) int {
+	if !seg.chunkable {
+		return 0
+	}
+	size := cfg.chunkSize
+	if size <= 0 {
+		workers := seg.workers(cfg)
+		if workers <= 1 || n < cfg.parallelThreshold {
+			return 0
+		}
+		size = (n + workers - 1) / workers
+	}
+	if n <= size {
+		return 0
+	}
+	return size
+}
+
+// workers returns the number of workers of seg: the WithSegmentWorkers
+// override, or else WithWorkers capped by the Concurrency of its stages.
+func (seg *segment) workers(cfg *lazyConfig) int {
+	if n, ok := cfg.segmentWorkers[seg.index]; ok {
+		return n
+	}
+	if seg.conc > 0 {
+		return min(seg.conc, cfg.workers)
+	}
+	return cfg.workers
+}
+
+// stream chains a fusible segment onto in, running it either sequentially
+// or in parallel.
+func (seg *segment) stream(in stream, cfg *lazyConfig) stream {
+	parallel := !seg.serial && seg.workers(cfg) > 1 && (in.max < 0 || in.max >= cfg.parallelThreshold)
+	var s stream
+	switch {
+	case seg.serial:
+		s = seg.executeSerial(in, cfg)
+	case parallel:
+		s = seg.executeParallel(in, cfg)
+	default:
+		s = seg.executeSequential(in, cfg)
+	}
+	if cfg.observer != nil {
+		s = seg.observed(s, parallel, cfg.observer)
+	}
+	s.max = in.max
+	if seg.expands() {
+		s.max = -1
 	}
-	return seg.executeSequential(items, cfg)
+	return s
+}
+
+// expands reports whether seg may emit more elements than it receives,
+// i.e. has a one-to-many stage.
+func (seg *segment) expands() bool {
+	return slices.ContainsFunc(seg.flatFns, func(fn flatFn) bool { return fn != nil })
 }
 
 // executeSequential runs fused ElemFn loop: for each element, apply all ElemFns.
-func (seg *segment) executeSequential(items []any, cfg *lazyConfig) ([]any, error) {
-	result := make([]any, 0, len(items))
-	for _, item := range items {
-		// Check context cancellation
-		select {
-		case <-cfg.ctx.Done():
-			return nil, cfg.ctx.Err()
-		default:
-		}
-
-		current := item
-		keep := true
-		var err error
-		for _, fn := range seg.elemFns {
-			current, keep, err = fn(current)
-			if err != nil {
-				return nil, err
+func (seg *segment) executeSequential(in stream, cfg *lazyConfig) stream {
+	return stream{
+		n: -1,
+		each: func(ctx context.Context, yield func(int, any) bool) error {
+			dst := output{emit: yield, batches: seg.newBatches()}
+			var pending elemResult
+			if cfg.elementTimeout > 0 {
+				// Outputs are handed on between elements, so that the time
+				// spent downstream does not count against their deadline;
+				// so are failures, which must not be dead-lettered on the
+				// element's context
+				dst.emit, dst.job = pending.add, &pending
+			}
+			report := func(dl DeadLetter) { cfg.report(ctx, dl) }
+			handOn := func() bool {
+				more := pending.yield(yield, report)
+				clear(pending.outs)
+				clear(pending.failed)
+				pending.outs, pending.failed = pending.outs[:0], pending.failed[:0]
+				return more
 			}
-			if !keep {
-				break
+
+			stopped := false
+			var fnErr error
+			err := in.each(ctx, func(index int, item any) bool {
+				// Check context cancellation
+				if fnErr = ctx.Err(); fnErr != nil {
+					return false
+				}
+
+				more, err := seg.apply(ctx, index, item, cfg, dst)
+				more = handOn() && more
+				if err != nil {
+					fnErr = err
+					return false
+				}
+				stopped = !more
+				return more
+			})
+			if fnErr != nil {
+				return fnErr
+			}
+			if err != nil || stopped {
+				return err
 			}
+			err = seg.flush(ctx, cfg, dst.batches)
+			handOn()
+			return err
+		},
+	}
+}
+
+// output is where a fused loop sends the elements that leave it.
+type output struct {
+	emit    func(index int, v any) bool
+	job     *elemResult // parallel mode or element timeouts: the result being filled
+	batches []batchBuf  // per-loop buffers of batching stages, see newBatches
+}
+
+// apply runs all fused stages on a single element and passes every
+// resulting element to dst.emit. It returns false once emit does.
+// A failing element is routed to the dead-letter sink, or recorded in
+// CollectAll mode, and dropped instead of returning the error.
+func (seg *segment) apply(ctx context.Context, index int, item any, cfg *lazyConfig, dst output) (bool, error) {
+	if cfg.elementTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = withTimeout(ctx, cfg.elementTimeout)
+		defer cancel()
+	}
+	return seg.applyFrom(ctx, 0, index, item, cfg, dst)
+}
+
+// applyFrom runs the fused stages from the from-th one on item.
+// One-to-many stages recurse into the remaining stages for every element
+// they expand to; batching stages hold the element until their batch is full.
+func (seg *segment) applyFrom(ctx context.Context, from, index int, item any, cfg *lazyConfig, dst output) (bool, error) {
+	current := item
+	for i := from; i < len(seg.elemFns); i++ {
+		var start time.Time
+		if seg.counters != nil {
+			start = time.Now()
+		}
+		if seg.flatFns[i] != nil {
+			return seg.expand(ctx, i, start, index, current, cfg, dst)
+		}
+		if seg.batchFns[i] != nil {
+			return seg.hold(ctx, i, index, current, cfg, dst)
+		}
+		out, ok, err := seg.elemFns[i](ctx, current)
+		if seg.counters != nil {
+			seg.record(i, time.Since(start), btoi(ok), err)
 		}
-		if keep {
-			result = append(result, current)
+		if err != nil {
+			return true, seg.fail(ctx, i, index, current, err, cfg, dst)
+		}
+		if !ok {
+			return true, nil
+		}
+		current = out
+	}
+	return dst.emit(index, current), nil
+}
+
+// expand runs the one-to-many stage i on item and the remaining stages on
+// each of its outputs.
+func (seg *segment) expand(ctx context.Context, i int, start time.Time, index int, item any, cfg *lazyConfig, dst output) (bool, error) {
+	n := 0
+	more := true
+	var downstream time.Duration // time spent in the remaining stages
+	var downErr error
+	err := seg.flatFns[i](ctx, item, func(v any) bool {
+		if ctx.Err() != nil {
+			downErr = ctxErr(ctx)
+			return false
+		}
+		n++
+		var t time.Time
+		if seg.counters != nil {
+			t = time.Now()
 		}
+		more, downErr = seg.applyFrom(ctx, i+1, index, v, cfg, dst)
+		if seg.counters != nil {
+			downstream += time.Since(t)
+		}
+		return downErr == nil && more
+	})
+	if te, ok := downErr.(*TimeoutError); ok && err == nil {
+		// The element deadline expired between two outputs:
+		// a failure of this stage rather than of the pipeline
+		err, downErr = te, nil
+	}
+	if seg.counters != nil {
+		seg.record(i, time.Since(start)-downstream, n, err)
+	}
+	if downErr != nil {
+		return false, downErr
+	}
+	if err != nil {
+		return true, seg.fail(ctx, i, index, item, err, cfg, dst)
+	}
+	return more, nil
+}
+
+// fail handles the error of stage i on the element at index.
+// It returns nil when the element is dropped instead of aborting.
+// In parallel mode, or with WithElementTimeout, the failure is kept with
+// the job of the element and only reported once the job is passed
+// downstream, see elemResult.yield. Otherwise ctx, the context of the run,
+// bounds the send to the dead-letter sink.
+func (seg *segment) fail(ctx context.Context, i, index int, value any, err error, cfg *lazyConfig, dst output) error {
+	if cfg.deadLetter == nil && cfg.errorMode != CollectAll {
+		return stageError(err, seg.names[i], seg.first+i, index)
+	}
+	dl := DeadLetter{Value: value, Index: index, Stage: seg.first + i, Name: seg.names[i], Err: err}
+	if dst.job != nil {
+		dst.job.failed = append(dst.job.failed, failure{pos: len(dst.job.outs), dl: dl})
+		return nil
+	}
+	cfg.report(ctx, dl)
+	return nil
+}
+
+func btoi(b bool) int {
+	if b {
+		return 1
 	}
-	return result, nil
+	return 0
 }
@@ -0,0 +1,121 @@
+package functional
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// batchFn is the erased form of a batching stage: run is called with up to
+// size elements at a time and returns one output per element.
+type batchFn struct {
+	size int
+	run  func(ctx context.Context, elems []any) ([]any, error)
+}
+
+func (fn *batchFn) spec() elemSpec {
+	return elemSpec{batch: fn}
+}
+
+// batchBuf holds the elements waiting for a batching stage.
+type batchBuf struct {
+	entries []batchEntry
+}
+
+// batchEntry is an element held by a batching stage, with everything
+// needed to resume the fused loop on its output.
+type batchEntry struct {
+	index int
+	value any
+	dst   output
+}
+
+// newBatches returns the buffers of one fused loop, one per stage so that
+// they can be indexed like elemFns, or nil when no stage batches.
+// Every run, and every worker of a parallel run, forms its own batches.
+func (seg *segment) newBatches() []batchBuf {
+	if !seg.batching {
+		return nil
+	}
+	return make([]batchBuf, len(seg.elemFns))
+}
+
+// hold adds item to the buffer of the batching stage i and runs the batch
+// once it is full.
+func (seg *segment) hold(ctx context.Context, i, index int, item any, cfg *lazyConfig, dst output) (bool, error) {
+	buf := &dst.batches[i]
+	buf.entries = append(buf.entries, batchEntry{index: index, value: item, dst: dst})
+	if dst.job != nil {
+		dst.job.held++
+	}
+	if len(buf.entries) < seg.batchFns[i].size {
+		return true, nil
+	}
+	return seg.runBatch(ctx, i, cfg, dst.batches)
+}
+
+// runBatch calls the batching stage i on the elements it holds and runs the
+// remaining stages on each of its outputs, in order.
+func (seg *segment) runBatch(ctx context.Context, i int, cfg *lazyConfig, batches []batchBuf) (bool, error) {
+	entries := batches[i].entries
+	values := make([]any, len(entries))
+	for j, e := range entries {
+		values[j] = e.value
+	}
+
+	start := time.Now()
+	outs, err := seg.batchFns[i].run(ctx, values)
+	elapsed := time.Since(start)
+	if err == nil && len(outs) != len(values) {
+		err = fmt.Errorf("Lazy: batch of %d elements returned %d results", len(values), len(outs))
+	}
+
+	// Later stages never feed stage i, so the buffer can be reused
+	// once every entry has been handed on
+	defer func() {
+		clear(entries)
+		batches[i].entries = entries[:0]
+	}()
+
+	more := true
+	for j, e := range entries {
+		if seg.counters != nil {
+			// The whole batch time is accounted to its first element
+			seg.record(i, elapsed, btoi(err == nil), err)
+			elapsed = 0
+		}
+		if e.dst.job != nil {
+			e.dst.job.held--
+		}
+		if err != nil {
+			if ferr := seg.fail(ctx, i, e.index, e.value, err, cfg, e.dst); ferr != nil {
+				return false, ferr
+			}
+			continue
+		}
+		if !more {
+			continue
+		}
+		var aerr error
+		if more, aerr = seg.applyFrom(ctx, i+1, e.index, outs[j], cfg, e.dst); aerr != nil {
+			return false, aerr
+		}
+	}
+	return more, nil
+}
+
+// flush runs the batching stages on the elements they still hold once the
+// input is exhausted. Earlier stages go first, since their outputs may
+// fill the buffers of later ones.
+func (seg *segment) flush(ctx context.Context, cfg *lazyConfig, batches []batchBuf) error {
+	for i := range batches {
+		if len(batches[i].entries) == 0 {
+			continue
+		}
+		more, err := seg.runBatch(ctx, i, cfg, batches)
+		if err != nil || !more {
+			return err
+		}
+	}
+	return nil
+}
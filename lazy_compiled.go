@@ -0,0 +1,91 @@
+package functional
+
+import (
+	"context"
+	"slices"
+)
+
+// Definition describes the stages of a pipeline without binding it to an
+// input. It is an immutable value: Elem, Stage, Pipe and Once return a new
+// Definition and leave the receiver untouched, so a base definition can be
+// branched into several pipelines.
+//
+//	base := Define[string, int]().Elem(LazyMapWithError[string, int](strconv.Atoi))
+//	evens := base.Elem(LazyFilter(func(n int) bool { return n%2 == 0 })).Compile()
+//	odds := base.Elem(LazyFilter(func(n int) bool { return n%2 == 1 })).Compile()
+type Definition[In, Out any] struct {
+	stages []stage
+}
+
+// Define starts an empty pipeline definition from In to Out.
+func Define[In, Out any]() Definition[In, Out] {
+	return Definition[In, Out]{}
+}
+
+// Elem returns a copy of d with element-level stages appended.
+// See LazyPipeline.Elem.
+func (d Definition[In, Out]) Elem(fns ...ElemFn) Definition[In, Out] {
+	return Definition[In, Out]{stages: appendElem(slices.Clip(d.stages), fns)}
+}
+
+// Stage returns a copy of d with element-level stages of any kind
+// appended. See LazyPipeline.Stage.
+func (d Definition[In, Out]) Stage(fns ...ElemStage) Definition[In, Out] {
+	return Definition[In, Out]{stages: appendElem(slices.Clip(d.stages), fns)}
+}
+
+// Pipe returns a copy of d with barrier stages appended.
+// See LazyPipeline.Pipe.
+func (d Definition[In, Out]) Pipe(fns ...BarrierStage) Definition[In, Out] {
+	return Definition[In, Out]{stages: appendPipe(slices.Clip(d.stages), fns)}
+}
+
+// Once returns a copy of d with a barrier that executes fn once per run.
+// See LazyPipeline.Once.
+func (d Definition[In, Out]) Once(fn func() error) Definition[In, Out] {
+	return Definition[In, Out]{stages: appendOnce(slices.Clip(d.stages), fn)}
+}
+
+// Compile lays out the segments of d once and returns a pipeline that can
+// be run on many inputs.
+func (d Definition[In, Out]) Compile() *CompiledPipeline[In, Out] {
+	stages := slices.Clone(d.stages)
+	return &CompiledPipeline[In, Out]{
+		stages:   stages,
+		segments: buildSegments(stages),
+	}
+}
+
+// CompiledPipeline is a pipeline whose segments have been computed ahead
+// of time by Compile. It holds no per-run state and RunOn may be called
+// concurrently from many goroutines.
+//
+// The stage functions are shared by every run; closures that capture
+// mutable state must be goroutine-safe.
+type CompiledPipeline[In, Out any] struct {
+	stages   []stage
+	segments []segment
+}
+
+// RunOn executes the pipeline on input and returns the final result.
+// ctx takes precedence over any WithContext in opts.
+// See LazyPipeline.Run.
+func (cp *CompiledPipeline[In, Out]) RunOn(ctx context.Context, input []In, opts ...LazyOption) ([]Out, error) {
+	return cp.bind(inputStream(input)).Run(append(slices.Clip(opts), WithContext(ctx))...)
+}
+
+// Validate checks the element types of the stages without running the
+// pipeline. See LazyPipeline.Validate.
+func (cp *CompiledPipeline[In, Out]) Validate() error {
+	return cp.bind(stream{}).Validate()
+}
+
+// bind returns a single-use LazyPipeline reading from source that shares
+// the precomputed segments of cp.
+func (cp *CompiledPipeline[In, Out]) bind(source stream) *LazyPipeline[In, Out] {
+	return &LazyPipeline[In, Out]{
+		source:   source,
+		stages:   cp.stages,
+		segments: cp.segments,
+	}
+}
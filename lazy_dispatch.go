@@ -0,0 +1,331 @@
+package functional
+
+import (
+	"context"
+	"sync"
+)
+
+// elemJob is a run of consecutive elements dispatched to a worker at once,
+// so that the cost of handing work over is shared by all of them.
+type elemJob struct {
+	seq int // dispatch order, used to restore input order
+
+	// A chunk [lo, hi) of a materialized input...
+	lo, hi int
+	at     func(int) any
+
+	// ...or elements gathered from a stream
+	indices []int
+	values  []any
+}
+
+// len returns the number of elements of the job.
+func (job *elemJob) len() int {
+	if job.at != nil {
+		return job.hi - job.lo
+	}
+	return len(job.values)
+}
+
+// elem returns the index and value of the j-th element of the job.
+func (job *elemJob) elem(j int) (int, any) {
+	if job.at != nil {
+		return job.lo + j, job.at(job.lo + j)
+	}
+	return job.indices[j], job.values[j]
+}
+
+// jobSource hands out the jobs of a parallel segment to its workers.
+type jobSource interface {
+	// next returns the next job of worker w, or false once there is none
+	// left or ctx is done.
+	next(ctx context.Context, w int) (elemJob, bool)
+}
+
+// jobSize returns the number of elements dispatched to a worker at once for
+// an input of n elements (-1 when unknown): WithChunkSize, or about 8 jobs
+// per worker, small enough for the first results to come out early.
+// It never exceeds WithMaxInFlight.
+func jobSize(n, workers int, cfg *lazyConfig) int {
+	var size int
+	switch {
+	case cfg.chunkSize > 0:
+		size = cfg.chunkSize
+	case n < 0:
+		size = 64
+	default:
+		size = min(max(n/(workers*8), 1), 64)
+	}
+	if cfg.maxInFlight > 0 {
+		size = min(size, cfg.maxInFlight)
+	}
+	return size
+}
+
+// chunkQueue is the jobSource of a materialized input. Chunks are dealt out
+// to workers round-robin up front, so that they all progress through the
+// input together. A worker takes its own chunks in order and, once it runs
+// out, steals the back half of those left to the busiest worker, so that a
+// few expensive chunks do not leave the others idle.
+type chunkQueue struct {
+	at    func(int) any
+	n     int
+	size  int
+	spans []chunkSpan
+}
+
+// chunkSpan holds the chunks left to a worker:
+// base + k*len(spans) for k in [next, end).
+type chunkSpan struct {
+	mu        sync.Mutex
+	base      int
+	next, end int
+}
+
+func newChunkQueue(in stream, size, workers int) *chunkQueue {
+	chunks := (in.n + size - 1) / size
+	q := &chunkQueue{at: in.at, n: in.n, size: size, spans: make([]chunkSpan, workers)}
+	for w := range q.spans {
+		q.spans[w].base = w
+		q.spans[w].end = max(chunks-w+workers-1, 0) / workers
+	}
+	return q
+}
+
+func (q *chunkQueue) next(_ context.Context, w int) (elemJob, bool) {
+	own := &q.spans[w]
+	for {
+		own.mu.Lock()
+		if own.next < own.end {
+			c := own.base + own.next*len(q.spans)
+			own.next++
+			own.mu.Unlock()
+			lo := c * q.size
+			return elemJob{seq: c, lo: lo, hi: min(lo+q.size, q.n), at: q.at}, true
+		}
+		own.mu.Unlock()
+
+		if !q.steal(w) {
+			return elemJob{}, false
+		}
+	}
+}
+
+// steal moves the back half of the largest span of the other workers to
+// worker w, whose span is empty. It reports false when every span is empty.
+func (q *chunkQueue) steal(w int) bool {
+	for {
+		victim, most := -1, 0
+		for v := range q.spans {
+			if v == w {
+				continue
+			}
+			s := &q.spans[v]
+			s.mu.Lock()
+			if left := s.end - s.next; left > most {
+				victim, most = v, left
+			}
+			s.mu.Unlock()
+		}
+		if victim < 0 {
+			return false
+		}
+
+		s := &q.spans[victim]
+		s.mu.Lock()
+		left := s.end - s.next
+		if left == 0 {
+			// Emptied in the meantime: look again
+			s.mu.Unlock()
+			continue
+		}
+		mid := s.next + left/2
+		base, end := s.base, s.end
+		s.end = mid
+		s.mu.Unlock()
+
+		// w's span is empty, so nobody steals from it until it is set
+		own := &q.spans[w]
+		own.mu.Lock()
+		own.base, own.next, own.end = base, mid, end
+		own.mu.Unlock()
+		return true
+	}
+}
+
+// elemQueue is the jobSource of a stream. The producer pushes elements one
+// at a time; a worker takes every element waiting, up to size, so that idle
+// workers get elements right away and busy ones take them in bulk.
+type elemQueue struct {
+	mu      sync.Mutex
+	indices []int
+	values  []any
+	seq     int
+	closed  bool
+	size    int
+	limit   int // elements waiting beyond which push blocks
+
+	ready chan struct{} // signalled when elements are waiting or the queue is closed
+	space chan struct{} // signalled when the queue drops below limit
+}
+
+func newElemQueue(size, workers int) *elemQueue {
+	return &elemQueue{
+		size:  size,
+		limit: size * workers,
+		ready: make(chan struct{}, 1),
+		space: make(chan struct{}, 1),
+	}
+}
+
+// signal wakes up one goroutine waiting on ch, if any.
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// push adds an element, waiting while the queue is full. It reports false
+// when ctx is done first.
+func (q *elemQueue) push(ctx context.Context, index int, v any) bool {
+	q.mu.Lock()
+	for len(q.values) >= q.limit {
+		q.mu.Unlock()
+		select {
+		case <-q.space:
+		case <-ctx.Done():
+			return false
+		}
+		q.mu.Lock()
+	}
+	q.indices = append(q.indices, index)
+	q.values = append(q.values, v)
+	if len(q.values) == 1 {
+		signal(q.ready)
+	}
+	q.mu.Unlock()
+	return true
+}
+
+// close marks the end of the stream: workers return once it is drained.
+func (q *elemQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	signal(q.ready)
+}
+
+func (q *elemQueue) next(ctx context.Context, _ int) (elemJob, bool) {
+	for {
+		q.mu.Lock()
+		if n := min(len(q.values), q.size); n > 0 {
+			full := len(q.values) >= q.limit
+			job := elemJob{
+				seq:     q.seq,
+				indices: append([]int(nil), q.indices[:n]...),
+				values:  append([]any(nil), q.values[:n]...),
+			}
+			q.seq++
+			rest := copy(q.values, q.values[n:])
+			clear(q.values[rest:])
+			q.values = q.values[:rest]
+			q.indices = q.indices[:copy(q.indices, q.indices[n:])]
+			if len(q.values) > 0 || q.closed {
+				// Pass the wake-up on to the next idle worker
+				signal(q.ready)
+			}
+			if full {
+				signal(q.space)
+			}
+			q.mu.Unlock()
+			return job, true
+		}
+		if q.closed {
+			q.mu.Unlock()
+			signal(q.ready)
+			return elemJob{}, false
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-q.ready:
+		case <-ctx.Done():
+			return elemJob{}, false
+		}
+	}
+}
+
+// window bounds the jobs of a parallel segment between dispatch and
+// collection. Ordered, it only admits the jobs within size of the next one
+// to be yielded, so that results cannot pile up behind a slow job;
+// unordered, it admits size jobs at a time. Either way, workers cannot get
+// far ahead of a consumer that stops early, e.g. at a LazyTake.
+// A nil window admits every job.
+type window struct {
+	mu       sync.Mutex
+	size     int
+	ordered  bool
+	head     int           // ordered: seq of the next job to be yielded
+	inFlight int           // unordered: jobs admitted and not yet yielded
+	moved    chan struct{} // closed, and replaced, whenever the window moves
+}
+
+// newWindow returns the window of a segment dispatching jobs of size
+// elements to workers workers: WithMaxInFlight elements, or else two jobs
+// per worker.
+func newWindow(size, workers int, cfg *lazyConfig) *window {
+	jobs := 2 * workers
+	if cfg.maxInFlight > 0 {
+		jobs = max(cfg.maxInFlight/size, 1)
+	}
+	return &window{
+		size:    jobs,
+		ordered: cfg.ordered,
+		moved:   make(chan struct{}),
+	}
+}
+
+// enter waits until job seq may be dispatched. If it has to wait, idle is
+// called first, to release whatever the worker holds back. It reports
+// false when ctx is done first, or idle fails.
+func (w *window) enter(ctx context.Context, seq int, idle func() error) (bool, error) {
+	if w == nil {
+		return true, nil
+	}
+	for waited := false; ; waited = true {
+		w.mu.Lock()
+		if w.ordered && seq < w.head+w.size || !w.ordered && w.inFlight < w.size {
+			w.inFlight++
+			w.mu.Unlock()
+			return true, nil
+		}
+		moved := w.moved
+		w.mu.Unlock()
+
+		if !waited && idle != nil {
+			if err := idle(); err != nil {
+				return false, err
+			}
+			continue
+		}
+		select {
+		case <-moved:
+		case <-ctx.Done():
+			return false, nil
+		}
+	}
+}
+
+// done records that job seq has been yielded downstream.
+func (w *window) done(seq int) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	w.inFlight--
+	w.head = seq + 1
+	close(w.moved)
+	w.moved = make(chan struct{})
+	w.mu.Unlock()
+}
@@ -1,5 +1,13 @@
 package functional
 
+import (
+	"context"
+	"errors"
+	"iter"
+	"reflect"
+	"time"
+)
+
 // ElemFn is an element-level transformation function that unifies map, filter,
 // and map+error into a single signature.
 //   - output: the transformed element
@@ -7,26 +15,139 @@ package functional
 //   - err: non-nil to abort the pipeline
 type ElemFn func(elem any) (output any, keep bool, err error)
 
+// ElemFnCtx is an ElemFn that also receives the pipeline context,
+// so that long-running calls can observe cancellation.
+type ElemFnCtx func(ctx context.Context, elem any) (output any, keep bool, err error)
+
+// ElemStage is an element-level stage accepted by LazyPipeline.Stage.
+// It is implemented by ElemFn, ElemFnCtx and the stages returned by the
+// constructors in this file; those returning an ElemStage (LazyMapTyped,
+// LazyFlatMap, ...) also record their element types for
+// LazyPipeline.Validate.
+type ElemStage interface {
+	spec() elemSpec
+}
+
+// flatFn is the erased form of a one-to-many stage. It passes the elements
+// elem expands to to yield, stopping when yield returns false; passing none
+// filters elem out.
+type flatFn func(ctx context.Context, elem any, yield func(any) bool) error
+
+// elemSpec is the erased form of an ElemStage.
+// Exactly one of fn, flat, serial, batch or group is non-nil.
+type elemSpec struct {
+	name    string
+	group   []elemSpec // stages grouped by Concurrency, never groups themselves
+	fn      ElemFnCtx
+	flat    flatFn
+	serial  serialFn
+	batch   *batchFn
+	workers int          // set by Concurrency; 0 = WithWorkers
+	through bool         // passes elements through unchanged, whatever their type
+	err     error        // reported by Validate and Run instead of running the stage
+	in, out reflect.Type // element types; nil when unknown (raw ElemFn)
+}
+
+func (s elemSpec) spec() elemSpec {
+	return s
+}
+
+func (fn ElemFn) spec() elemSpec {
+	return elemSpec{fn: func(_ context.Context, elem any) (any, bool, error) {
+		return fn(elem)
+	}}
+}
+
+func (fn ElemFnCtx) spec() elemSpec {
+	return elemSpec{fn: fn}
+}
+
+func (fn flatFn) spec() elemSpec {
+	return elemSpec{flat: fn}
+}
+
+// each returns a copy of s with f applied to every stage it stands for:
+// the members of a group, or s itself.
+func (s elemSpec) each(f func(elemSpec) elemSpec) elemSpec {
+	if s.group == nil {
+		return f(s)
+	}
+	group := make([]elemSpec, len(s.group))
+	for i, m := range s.group {
+		group[i] = f(m)
+	}
+	s.group = group
+	return s
+}
+
+// stages returns the stages s stands for.
+func (s elemSpec) stages() []elemSpec {
+	if s.group == nil {
+		return []elemSpec{s}
+	}
+	return s.group
+}
+
+// typed records In and Out as the element types of fn.
+func typed[In, Out any](fn ElemStage) ElemStage {
+	s := fn.spec()
+	s.in = reflect.TypeFor[In]()
+	s.out = reflect.TypeFor[Out]()
+	return s
+}
+
+// Named gives an element-level stage a name. The name is reported in
+// StageError, DeadLetter, Observer events and Explain output.
+//
+//	Stage(Named("parse", LazyMapWithError[string, int](strconv.Atoi)))
+//
+// Every stage of a Concurrency group gets the name.
+func Named(name string, fn ElemStage) ElemStage {
+	return fn.spec().each(func(s elemSpec) elemSpec {
+		s.name = name
+		return s
+	})
+}
+
 // LazyMap returns an ElemFn that transforms each element using fn.
+//
+// An ElemFn is a plain func, so In and Out are not recorded and
+// LazyPipeline.Validate cannot check the stage: a LazyMap[int, string]
+// followed by a LazyFilter[int] still only fails at run time. Use
+// LazyMapTyped, LazyFilterTyped and the other ...Typed forms with Stage
+// for stages Validate should check.
 func LazyMap[In, Out any](fn func(In) Out) ElemFn {
 	return func(elem any) (any, bool, error) {
-		return fn(elem.(In)), true, nil
+		v, err := as[In](elem)
+		if err != nil {
+			return nil, false, err
+		}
+		return fn(v), true, nil
 	}
 }
 
 // LazyFilter returns an ElemFn that keeps only elements satisfying the predicate.
+// Validate does not check it, see LazyMap; LazyFilterTyped does.
 func LazyFilter[T any](fn func(T) bool) ElemFn {
 	return func(elem any) (any, bool, error) {
-		v := elem.(T)
+		v, err := as[T](elem)
+		if err != nil {
+			return nil, false, err
+		}
 		return v, fn(v), nil
 	}
 }
 
 // LazyMapWithError returns an ElemFn that transforms each element using fn,
-// propagating any error to abort the pipeline.
+// propagating any error to abort the pipeline. Validate does not check it,
+// see LazyMap; LazyMapWithErrorTyped does.
 func LazyMapWithError[In, Out any](fn func(In) (Out, error)) ElemFn {
 	return func(elem any) (any, bool, error) {
-		out, err := fn(elem.(In))
+		v, err := as[In](elem)
+		if err != nil {
+			return nil, false, err
+		}
+		out, err := fn(v)
 		if err != nil {
 			return nil, false, err
 		}
@@ -36,9 +157,14 @@ func LazyMapWithError[In, Out any](fn func(In) (Out, error)) ElemFn {
 
 // LazyFilterMap returns an ElemFn that transforms and optionally filters elements.
 // If fn returns false as the second value, the element is excluded.
+// Validate does not check it, see LazyMap; LazyFilterMapTyped does.
 func LazyFilterMap[In, Out any](fn func(In) (Out, bool)) ElemFn {
 	return func(elem any) (any, bool, error) {
-		out, keep := fn(elem.(In))
+		v, err := as[In](elem)
+		if err != nil {
+			return nil, false, err
+		}
+		out, keep := fn(v)
 		return out, keep, nil
 	}
 }
@@ -49,9 +175,14 @@ func LazyFilterMap[In, Out any](fn func(In) (Out, bool)) ElemFn {
 // When used with WithWorkers(n), fn may be called from multiple goroutines
 // concurrently. The caller is responsible for ensuring fn is goroutine-safe.
 // WithOrdered(true) guarantees output order but not side-effect invocation order.
+//
+// Validate does not check it, see LazyMap; LazyTapTyped does.
 func LazyTap[T any](fn func(T)) ElemFn {
 	return func(elem any) (any, bool, error) {
-		v := elem.(T)
+		v, err := as[T](elem)
+		if err != nil {
+			return nil, false, err
+		}
 		fn(v)
 		return v, true, nil
 	}
@@ -63,12 +194,444 @@ func LazyTap[T any](fn func(T)) ElemFn {
 //
 // When used with WithWorkers(n), fn may be called from multiple goroutines
 // concurrently. The caller is responsible for ensuring fn is goroutine-safe.
+//
+// Validate does not check it, see LazyMap; LazyTapWithErrorTyped does.
 func LazyTapWithError[T any](fn func(T) error) ElemFn {
 	return func(elem any) (any, bool, error) {
-		v := elem.(T)
+		v, err := as[T](elem)
+		if err != nil {
+			return nil, false, err
+		}
 		if err := fn(v); err != nil {
 			return nil, false, err
 		}
 		return v, true, nil
 	}
 }
+
+// LazyMapTyped is LazyMap as an ElemStage recording In and Out, so that
+// LazyPipeline.Validate can check it.
+func LazyMapTyped[In, Out any](fn func(In) Out) ElemStage {
+	return typed[In, Out](LazyMap(fn))
+}
+
+// LazyFilterTyped is LazyFilter as an ElemStage recording T, see LazyMapTyped.
+func LazyFilterTyped[T any](fn func(T) bool) ElemStage {
+	return typed[T, T](LazyFilter(fn))
+}
+
+// LazyMapWithErrorTyped is LazyMapWithError as an ElemStage recording In and
+// Out, see LazyMapTyped.
+func LazyMapWithErrorTyped[In, Out any](fn func(In) (Out, error)) ElemStage {
+	return typed[In, Out](LazyMapWithError(fn))
+}
+
+// LazyFilterMapTyped is LazyFilterMap as an ElemStage recording In and Out,
+// see LazyMapTyped.
+func LazyFilterMapTyped[In, Out any](fn func(In) (Out, bool)) ElemStage {
+	return typed[In, Out](LazyFilterMap(fn))
+}
+
+// LazyTapTyped is LazyTap as an ElemStage recording T, see LazyMapTyped.
+func LazyTapTyped[T any](fn func(T)) ElemStage {
+	return typed[T, T](LazyTap(fn))
+}
+
+// LazyTapWithErrorTyped is LazyTapWithError as an ElemStage recording T,
+// see LazyMapTyped.
+func LazyTapWithErrorTyped[T any](fn func(T) error) ElemStage {
+	return typed[T, T](LazyTapWithError(fn))
+}
+
+// LazyMapCtx returns an element-level stage that transforms each element using fn.
+// fn receives the pipeline context, which is cancelled when the pipeline
+// is aborted (by WithContext, by another element's error in parallel mode,
+// or by the consumer of Seq breaking out of its loop).
+func LazyMapCtx[In, Out any](fn func(context.Context, In) (Out, error)) ElemStage {
+	return typed[In, Out](ElemFnCtx(func(ctx context.Context, elem any) (any, bool, error) {
+		v, err := as[In](elem)
+		if err != nil {
+			return nil, false, err
+		}
+		out, err := fn(ctx, v)
+		if err != nil {
+			return nil, false, err
+		}
+		return out, true, nil
+	}))
+}
+
+// LazyFilterCtx returns an element-level stage that keeps only elements satisfying
+// the predicate. fn receives the pipeline context, see LazyMapCtx.
+func LazyFilterCtx[T any](fn func(context.Context, T) (bool, error)) ElemStage {
+	return typed[T, T](ElemFnCtx(func(ctx context.Context, elem any) (any, bool, error) {
+		v, err := as[T](elem)
+		if err != nil {
+			return nil, false, err
+		}
+		keep, err := fn(ctx, v)
+		if err != nil {
+			return nil, false, err
+		}
+		return v, keep, nil
+	}))
+}
+
+// LazyTapCtx returns an element-level stage that applies a side-effect function to each
+// element without modifying it. fn receives the pipeline context, see LazyMapCtx.
+//
+// When used with WithWorkers(n), fn may be called from multiple goroutines
+// concurrently. The caller is responsible for ensuring fn is goroutine-safe.
+func LazyTapCtx[T any](fn func(context.Context, T) error) ElemStage {
+	return typed[T, T](ElemFnCtx(func(ctx context.Context, elem any) (any, bool, error) {
+		v, err := as[T](elem)
+		if err != nil {
+			return nil, false, err
+		}
+		if err := fn(ctx, v); err != nil {
+			return nil, false, err
+		}
+		return v, true, nil
+	}))
+}
+
+// LazyFlatMap returns an element-level stage that expands each element into
+// the elements of the slice returned by fn. An empty slice filters the
+// element out. The stage stays fused with its neighbours.
+//
+// When used with WithWorkers(n), the outputs of one element are kept
+// together and in order; WithOrdered(true) also keeps the groups in input order.
+func LazyFlatMap[In, Out any](fn func(In) []Out) ElemStage {
+	return typed[In, Out](flatFn(func(_ context.Context, elem any, yield func(any) bool) error {
+		v, err := as[In](elem)
+		if err != nil {
+			return err
+		}
+		for _, out := range fn(v) {
+			if !yield(out) {
+				break
+			}
+		}
+		return nil
+	}))
+}
+
+// LazyFlatMapSeq returns an element-level stage that expands each element into
+// the elements of the sequence returned by fn. The sequence is consumed
+// lazily in sequential mode and stops early when the consumer of Seq stops;
+// in parallel mode it is consumed whole by the worker, see LazyFlatMap.
+func LazyFlatMapSeq[In, Out any](fn func(In) iter.Seq[Out]) ElemStage {
+	return typed[In, Out](flatFn(func(_ context.Context, elem any, yield func(any) bool) error {
+		v, err := as[In](elem)
+		if err != nil {
+			return err
+		}
+		for out := range fn(v) {
+			if !yield(out) {
+				break
+			}
+		}
+		return nil
+	}))
+}
+
+// LazyTake returns an element-level stage that keeps the first n elements
+// and then stops the pipeline: no further input is read and no further
+// element is processed by the stages before it. With n <= 0, no input is
+// read at all.
+//
+// When used with WithWorkers(n), the stages before LazyTake still run in
+// parallel and LazyTake sees their output in the order it is yielded: the
+// first n elements of the input with WithOrdered(true), otherwise the
+// first n to finish.
+func LazyTake[T any](n int) ElemStage {
+	return typed[T, T](serialFn(func() stepFn {
+		if n <= 0 {
+			return nil
+		}
+		taken := 0
+		return func(_ context.Context, _ int, elem any) (any, bool, bool, error) {
+			if taken >= n {
+				return nil, false, true, nil
+			}
+			taken++
+			return elem, true, taken >= n, nil
+		}
+	}))
+}
+
+// LazyLimit is LazyTake.
+func LazyLimit[T any](n int) ElemStage {
+	return LazyTake[T](n)
+}
+
+// LazyTakeWhile returns an element-level stage that keeps elements while fn
+// returns true and stops the pipeline at the first element for which it
+// returns false. See LazyTake for the behaviour with WithWorkers(n).
+func LazyTakeWhile[T any](fn func(T) bool) ElemStage {
+	return typed[T, T](serialFn(func() stepFn {
+		return func(_ context.Context, _ int, elem any) (any, bool, bool, error) {
+			v, err := as[T](elem)
+			if err != nil {
+				return nil, false, false, err
+			}
+			if !fn(v) {
+				return nil, false, true, nil
+			}
+			return v, true, false, nil
+		}
+	}))
+}
+
+// LazyDrop returns an element-level stage that skips the first n elements
+// and keeps the rest. See LazyTake for the behaviour with WithWorkers(n).
+func LazyDrop[T any](n int) ElemStage {
+	return typed[T, T](serialFn(func() stepFn {
+		dropped := 0
+		return func(_ context.Context, _ int, elem any) (any, bool, bool, error) {
+			if dropped < n {
+				dropped++
+				return nil, false, false, nil
+			}
+			return elem, true, false, nil
+		}
+	}))
+}
+
+// LazyDropWhile returns an element-level stage that skips elements while fn
+// returns true and keeps every element from the first one for which it
+// returns false. See LazyTake for the behaviour with WithWorkers(n).
+func LazyDropWhile[T any](fn func(T) bool) ElemStage {
+	return typed[T, T](serialFn(func() stepFn {
+		dropping := true
+		return func(_ context.Context, _ int, elem any) (any, bool, bool, error) {
+			v, err := as[T](elem)
+			if err != nil {
+				return nil, false, false, err
+			}
+			if dropping && fn(v) {
+				return nil, false, false, nil
+			}
+			dropping = false
+			return v, true, false, nil
+		}
+	}))
+}
+
+// LazyDistinct returns an element-level stage that drops elements equal to
+// one it has already kept. It remembers every kept element for the rest of
+// the run.
+//
+// Like LazyTake it runs sequentially, on elements in the order the previous
+// stages yield them: with WithWorkers(n) the first occurrence in the input
+// is kept only with WithOrdered(true).
+func LazyDistinct[T comparable]() ElemStage {
+	return LazyDistinctBy[T, T](func(v T) T { return v })
+}
+
+// LazyDistinctBy returns an element-level stage that drops elements whose
+// key is equal to the key of one it has already kept. See LazyDistinct.
+func LazyDistinctBy[T any, K comparable](key func(T) K) ElemStage {
+	return typed[T, T](serialFn(func() stepFn {
+		seen := make(map[K]struct{})
+		return func(_ context.Context, _ int, elem any) (any, bool, bool, error) {
+			v, err := as[T](elem)
+			if err != nil {
+				return nil, false, false, err
+			}
+			k := key(v)
+			if _, ok := seen[k]; ok {
+				return nil, false, false, nil
+			}
+			seen[k] = struct{}{}
+			return v, true, false, nil
+		}
+	}))
+}
+
+// LazyScan returns an element-level stage that emits the running
+// accumulation of fn over the elements, starting from init.
+//
+//	Stage(LazyScan(0, func(sum, n int) int { return sum + n })) // 1, 2, 3 -> 1, 3, 6
+//
+// Like LazyTake it runs sequentially, on elements in the order the previous
+// stages yield them; use WithOrdered(true) with WithWorkers(n) to
+// accumulate in input order.
+func LazyScan[T, Acc any](init Acc, fn func(Acc, T) Acc) ElemStage {
+	return typed[T, Acc](serialFn(func() stepFn {
+		acc := init
+		return func(_ context.Context, _ int, elem any) (any, bool, bool, error) {
+			v, err := as[T](elem)
+			if err != nil {
+				return nil, false, false, err
+			}
+			acc = fn(acc, v)
+			return acc, true, false, nil
+		}
+	}))
+}
+
+// LazyWithIndex returns an element-level stage that pairs each element with
+// its position in the pipeline input, or in the output of the last barrier
+// when there is one. Positions are kept across filters, so they may have
+// gaps, and do not depend on WithWorkers or WithOrdered.
+func LazyWithIndex[T any]() ElemStage {
+	return typed[T, Pair[int, T]](serialFn(func() stepFn {
+		return func(_ context.Context, index int, elem any) (any, bool, bool, error) {
+			v, err := as[T](elem)
+			if err != nil {
+				return nil, false, false, err
+			}
+			return Pair[int, T]{Key: index, Value: v}, true, false, nil
+		}
+	}))
+}
+
+// LazyBatchMap returns an element-level stage that calls fn once for every
+// size elements instead of once per element, e.g. to replace per-element
+// lookups with a single query. fn must return one output per input, in the
+// same order; the outputs continue through the fused loop in input order.
+// The last, possibly smaller, batch is flushed when the input is exhausted.
+// An error from fn fails every element of the batch.
+//
+// When used with WithWorkers(n), each worker forms its own batches from the
+// elements it receives, and fn may be called from multiple goroutines
+// concurrently. Output order follows WithOrdered as usual.
+func LazyBatchMap[In, Out any](size int, fn func([]In) ([]Out, error)) ElemStage {
+	return typed[In, Out](&batchFn{
+		size: max(size, 1),
+		run: func(_ context.Context, elems []any) ([]any, error) {
+			in := make([]In, len(elems))
+			for i, elem := range elems {
+				v, err := as[In](elem)
+				if err != nil {
+					return nil, err
+				}
+				in[i] = v
+			}
+			outs, err := fn(in)
+			if err != nil {
+				return nil, err
+			}
+			result := make([]any, len(outs))
+			for i, out := range outs {
+				result[i] = out
+			}
+			return result, nil
+		},
+	})
+}
+
+// LazyRateLimit returns an element-level stage that passes elements through
+// unchanged at no more than rps elements per second, with bursts of up to
+// burst elements. Place it right before the stage calling a throttled
+// service. A non-positive rps disables the limit.
+//
+// The limit is shared by every worker, and by every run of a compiled
+// pipeline using the returned stage. Waiting for a token is aborted when the
+// pipeline context is cancelled.
+func LazyRateLimit(rps float64, burst int) ElemStage {
+	if rps <= 0 {
+		return elemSpec{through: true, fn: func(_ context.Context, elem any) (any, bool, error) {
+			return elem, true, nil
+		}}
+	}
+	l := newRateLimiter(rps, burst)
+	return elemSpec{through: true, fn: func(ctx context.Context, elem any) (any, bool, error) {
+		if err := l.wait(ctx); err != nil {
+			return nil, false, err
+		}
+		return elem, true, nil
+	}}
+}
+
+// Concurrency caps the number of workers running fns at n, e.g. to keep
+// a stage calling a throttled service from being hammered by every worker
+// of WithWorkers. The stages get a fused segment of their own, with a
+// worker pool of n workers, or fewer if WithWorkers is smaller (n = 1 runs
+// them sequentially); they still run concurrently with the rest of the
+// pipeline. A sequential pipeline stays sequential. WithSegmentWorkers
+// takes precedence.
+//
+// The returned stage stands for all of fns; LazyRetry, LazyTimeout and
+// Named apply to each of them.
+//
+//	Stage(parse, Concurrency(2, LazyRateLimit(10, 1), call))
+func Concurrency(n int, fns ...ElemStage) ElemStage {
+	var group []elemSpec
+	for _, fn := range fns {
+		// A nested group is flattened, the outer n wins
+		group = append(group, fn.spec().each(func(s elemSpec) elemSpec {
+			s.workers = max(n, 1)
+			return s
+		}).stages()...)
+	}
+	return elemSpec{group: group}
+}
+
+// LazyRetry returns an element-level stage that re-invokes fn when it
+// returns an error, following policy. Between attempts it sleeps with
+// exponential backoff; the sleep is aborted when the pipeline context is
+// cancelled. Filtered-out elements (keep == false without an error) are
+// not retried. The name and element types of fn are kept.
+//
+// Order-sensitive stages such as LazyTake keep state between elements, and
+// one-to-many stages may already have emitted elements when they fail, so
+// neither can be retried: the pipeline fails with a *StageError before
+// running, and Validate reports it.
+func LazyRetry(fn ElemStage, policy RetryPolicy) ElemStage {
+	return fn.spec().each(func(s elemSpec) elemSpec {
+		return retrySpec(s, policy)
+	})
+}
+
+// retrySpec makes the function of s retry following policy.
+func retrySpec(s elemSpec, policy RetryPolicy) elemSpec {
+	if s.serial != nil || s.flat != nil {
+		s.err = errors.New("LazyRetry: order-sensitive and one-to-many stages cannot be retried")
+		return s
+	}
+	if s.batch != nil {
+		inner := *s.batch
+		s.batch = &batchFn{size: inner.size, run: func(ctx context.Context, elems []any) ([]any, error) {
+			var outs []any
+			err := policy.do(ctx, func() error {
+				var err error
+				outs, err = inner.run(ctx, elems)
+				return err
+			})
+			return outs, err
+		}}
+		return s
+	}
+	inner := s.fn
+	s.fn = func(ctx context.Context, elem any) (any, bool, error) {
+		var out any
+		var keep bool
+		err := policy.do(ctx, func() error {
+			var err error
+			out, keep, err = inner(ctx, elem)
+			return err
+		})
+		if err != nil {
+			return nil, false, err
+		}
+		return out, keep, nil
+	}
+	return s
+}
+
+// LazyTimeout returns an element-level stage that fails an element with a
+// *TimeoutError when fn takes longer than d on it. Context-aware functions
+// (LazyMapCtx, ...) receive a context with the deadline; a call still
+// running at the deadline is abandoned, so that a slow element does not
+// stall the segment. The outputs of a one-to-many fn are handed on once it
+// returns, so that only fn's own work counts against d. See
+// WithElementTimeout for a limit on the whole segment. The name and element
+// types of fn are kept. Order-sensitive stages such as LazyScan cannot time
+// out; Validate and Run report them as a *StageError.
+func LazyTimeout(d time.Duration, fn ElemStage) ElemStage {
+	return fn.spec().each(func(s elemSpec) elemSpec {
+		return timeoutSpec(s, d)
+	})
+}
@@ -0,0 +1,48 @@
+package functional
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"slices"
+	"sync"
+)
+
+// report hands a failed element to the dead-letter sink or, in CollectAll
+// mode, records its error. A blocked sink gives up once ctx is done.
+func (cfg *lazyConfig) report(ctx context.Context, dl DeadLetter) {
+	if cfg.deadLetter != nil {
+		cfg.deadLetter(ctx, dl)
+		return
+	}
+	cfg.elemErrs.add(stageError(dl.Err, dl.Name, dl.Stage, dl.Index))
+}
+
+// elemErrors collects element failures from concurrent workers.
+type elemErrors struct {
+	mu   sync.Mutex
+	errs []*StageError
+}
+
+func (e *elemErrors) add(err *StageError) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.errs = append(e.errs, err)
+}
+
+// err returns every collected failure joined in input order, or nil.
+func (e *elemErrors) err() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.errs) == 0 {
+		return nil
+	}
+	slices.SortStableFunc(e.errs, func(a, b *StageError) int {
+		return cmp.Or(cmp.Compare(a.Index, b.Index), cmp.Compare(a.StageIndex, b.StageIndex))
+	})
+	errs := make([]error, len(e.errs))
+	for i, se := range e.errs {
+		errs[i] = se
+	}
+	return errors.Join(errs...)
+}
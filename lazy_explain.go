@@ -0,0 +1,174 @@
+package functional
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Plan describes how a LazyPipeline will be executed:
+// which Elem stages are fused into one loop, where barriers split them,
+// and which segments run in parallel.
+type Plan struct {
+	Segments []PlanSegment
+	Workers  int // 0 or 1 = sequential
+	Analyzed bool
+}
+
+// PlanSegment is a single segment of a Plan.
+type PlanSegment struct {
+	SegmentInfo
+
+	// SizeDependent is true when whether the segment runs in parallel is
+	// only known at run time, from the number of elements materialized by
+	// the preceding barrier (compared against WithParallelThreshold and
+	// WithChunkSize). Parallel is then false.
+	SizeDependent bool
+
+	// Workers is the number of workers the segment runs with when parallel:
+	// WithWorkers, capped by Concurrency, unless overridden by WithSegmentWorkers.
+	Workers int
+
+	// Filled by ExplainAnalyze only.
+	Stats   []StageStats
+	Elapsed time.Duration
+}
+
+// Plan returns the execution plan of the pipeline for the given options
+// without running it.
+func (lp *LazyPipeline[In, Out]) Plan(opts ...LazyOption) Plan {
+	cfg := newConfig(opts)
+	plan := Plan{Workers: cfg.workers}
+	// Upper bound on the input of the next segment, as in stream.max;
+	// unknown until run time after a barrier
+	bound, sized := lp.source.max, true
+	for _, seg := range buildSegments(lp.stages) {
+		ps := PlanSegment{Workers: seg.workers(cfg)}
+		switch {
+		case ps.Workers <= 1, seg.serial:
+		case seg.barrierFn != nil:
+			ps.SizeDependent = seg.chunkable
+		case !sized:
+			ps.SizeDependent = true
+		default:
+			ps.Parallel = bound < 0 || bound >= cfg.parallelThreshold
+		}
+		switch {
+		case seg.barrierFn != nil:
+			sized = false
+		case seg.expands():
+			bound, sized = -1, true
+		}
+		ps.SegmentInfo = seg.info(ps.Parallel)
+		plan.Segments = append(plan.Segments, ps)
+	}
+	return plan
+}
+
+// Explain returns a human-readable description of Plan(opts...).
+func (lp *LazyPipeline[In, Out]) Explain(opts ...LazyOption) string {
+	return lp.Plan(opts...).String()
+}
+
+// ExplainAnalyze runs the pipeline with opts, discarding the results,
+// and returns its plan annotated with the element counts and time spent
+// in every stage. The error is the one Run would return.
+func (lp *LazyPipeline[In, Out]) ExplainAnalyze(opts ...LazyOption) (string, error) {
+	plan := lp.Plan(opts...)
+	plan.Analyzed = true
+
+	a := &analyzer{plan: &plan}
+	_, err := lp.Run(append(slices.Clip(opts), func(c *lazyConfig) {
+		a.next = c.observer
+		c.observer = a
+	})...)
+	return plan.String(), err
+}
+
+// String formats the plan, one line per segment followed by its stages.
+func (p Plan) String() string {
+	var b strings.Builder
+	for _, seg := range p.Segments {
+		fmt.Fprintf(&b, "Segment %d: %s", seg.Index, seg.describe())
+		if p.Analyzed {
+			fmt.Fprintf(&b, " [time=%s]", seg.Elapsed)
+		}
+		b.WriteString("\n")
+		for i, st := range seg.Stages {
+			fmt.Fprintf(&b, "  stage %d: %s", st.Index, st.Kind)
+			if st.Name != "" {
+				fmt.Fprintf(&b, " %q", st.Name)
+			}
+			if p.Analyzed && i < len(seg.Stats) {
+				s := seg.Stats[i]
+				fmt.Fprintf(&b, " [in=%d out=%d filtered=%d errors=%d time=%s]",
+					s.In, s.Out, s.Filtered, s.Errors, s.Duration)
+			}
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
+
+// describe returns a one-line summary of how the segment runs.
+func (seg PlanSegment) describe() string {
+	var what string
+	if seg.Barrier {
+		what = "barrier (materializes input)"
+	} else {
+		what = fmt.Sprintf("fused loop of %d stage(s)", len(seg.Stages))
+	}
+
+	switch {
+	case seg.Parallel:
+		return fmt.Sprintf("%s, parallel with %d workers", what, seg.Workers)
+	case seg.SizeDependent:
+		return fmt.Sprintf("%s, parallel with %d workers if input is large enough", what, seg.Workers)
+	default:
+		return what + ", sequential"
+	}
+}
+
+// analyzer is the Observer used by ExplainAnalyze to fill a Plan.
+// It forwards every event to the user-supplied Observer, if any.
+type analyzer struct {
+	mu   sync.Mutex
+	plan *Plan
+	next Observer
+}
+
+func (a *analyzer) SegmentStart(seg SegmentInfo) {
+	if a.next != nil {
+		a.next.SegmentStart(seg)
+	}
+}
+
+func (a *analyzer) SegmentEnd(seg SegmentInfo, stats []StageStats, elapsed time.Duration, err error) {
+	a.mu.Lock()
+	ps := &a.plan.Segments[seg.Index]
+	ps.Parallel, ps.SizeDependent = seg.Parallel, false
+	ps.Stats, ps.Elapsed = stats, elapsed
+	a.mu.Unlock()
+	if a.next != nil {
+		a.next.SegmentEnd(seg, stats, elapsed, err)
+	}
+}
+
+func (a *analyzer) BarrierStart(seg SegmentInfo, n int) {
+	if a.next != nil {
+		a.next.BarrierStart(seg, n)
+	}
+}
+
+func (a *analyzer) BarrierEnd(seg SegmentInfo, stats StageStats, err error) {
+	a.mu.Lock()
+	ps := &a.plan.Segments[seg.Index]
+	ps.Parallel, ps.SizeDependent = seg.Parallel, false
+	ps.Stats, ps.Elapsed = []StageStats{stats}, stats.Duration
+	a.mu.Unlock()
+	if a.next != nil {
+		a.next.BarrierEnd(seg, stats, err)
+	}
+}
@@ -0,0 +1,187 @@
+package functional
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// Observer receives execution events from a LazyPipeline.
+// Register it with WithObserver. Embed NopObserver to implement only the
+// callbacks you need.
+//
+// When used with WithWorkers(n), callbacks of different segments may be
+// invoked from different goroutines. The caller is responsible for ensuring
+// the Observer is goroutine-safe.
+type Observer interface {
+	// SegmentStart is called when a fused segment starts pulling elements.
+	SegmentStart(seg SegmentInfo)
+	// SegmentEnd is called when a fused segment has stopped, with the
+	// per-stage statistics and the error that stopped it, if any.
+	SegmentEnd(seg SegmentInfo, stats []StageStats, elapsed time.Duration, err error)
+	// BarrierStart is called after the input of a barrier is materialized,
+	// right before the barrier runs on n elements.
+	BarrierStart(seg SegmentInfo, n int)
+	// BarrierEnd is called when a barrier has finished.
+	BarrierEnd(seg SegmentInfo, stats StageStats, err error)
+}
+
+// NopObserver is an Observer that ignores every event.
+// Embed it to implement only some of the Observer callbacks.
+type NopObserver struct{}
+
+func (NopObserver) SegmentStart(SegmentInfo)                                   {}
+func (NopObserver) SegmentEnd(SegmentInfo, []StageStats, time.Duration, error) {}
+func (NopObserver) BarrierStart(SegmentInfo, int)                              {}
+func (NopObserver) BarrierEnd(SegmentInfo, StageStats, error)                  {}
+
+// SegmentInfo describes a segment as laid out by the pipeline:
+// either a group of fused Elem stages or a single barrier.
+type SegmentInfo struct {
+	Index    int         // position of the segment in the pipeline
+	Stages   []StageInfo // stages fused into this segment
+	Barrier  bool        // true for Pipe and Once stages
+	Parallel bool        // true when the segment runs on the worker pool
+}
+
+// StageInfo identifies a single stage of the pipeline.
+type StageInfo struct {
+	Index int    // position of the stage in the pipeline
+	Name  string // stage name given with Named or NamedBarrier, or ""
+	Kind  StageKind
+}
+
+// StageKind tells how a stage was added to the pipeline.
+type StageKind int
+
+const (
+	StageElem        StageKind = iota // added with Elem or Stage, fused with its neighbours
+	StagePipe                         // added with Pipe, a barrier
+	StageChunkedPipe                  // added with Pipe from ChunkedBarrier
+	StageOnce                         // added with Once, a barrier
+)
+
+func (k StageKind) String() string {
+	switch k {
+	case StageElem:
+		return "Elem"
+	case StagePipe:
+		return "Pipe"
+	case StageChunkedPipe:
+		return "Pipe(chunked)"
+	case StageOnce:
+		return "Once"
+	}
+	return fmt.Sprintf("StageKind(%d)", int(k))
+}
+
+// StageStats holds the counters of a single stage for one execution.
+type StageStats struct {
+	Stage    StageInfo
+	In       int64         // elements passed to the stage
+	Out      int64         // elements the stage emitted
+	Filtered int64         // elements the stage filtered out
+	Errors   int64         // elements the stage failed on
+	Duration time.Duration // total time spent in the stage, summed across workers
+}
+
+// WithObserver registers an Observer that receives segment and barrier
+// events with per-stage element counts and durations.
+// Counting adds a small per-element overhead, paid only when an Observer is set.
+func WithObserver(o Observer) LazyOption {
+	return func(c *lazyConfig) {
+		c.observer = o
+	}
+}
+
+// stageCounters accumulates StageStats from concurrent workers.
+type stageCounters struct {
+	in, out, filtered, errors, nanos atomic.Int64
+}
+
+// info returns the SegmentInfo describing seg.
+func (seg *segment) info(parallel bool) SegmentInfo {
+	n := len(seg.elemFns)
+	if seg.barrierFn != nil {
+		n = 1
+	}
+	stages := make([]StageInfo, n)
+	for i := range stages {
+		stages[i] = StageInfo{Index: seg.first + i, Name: seg.names[i], Kind: seg.kind}
+	}
+	return SegmentInfo{
+		Index:    seg.index,
+		Stages:   stages,
+		Barrier:  seg.barrierFn != nil,
+		Parallel: parallel,
+	}
+}
+
+// record updates the counters of the i-th fused stage after one call
+// that emitted out elements.
+func (seg *segment) record(i int, elapsed time.Duration, out int, err error) {
+	c := &seg.counters[i]
+	c.nanos.Add(int64(elapsed))
+	c.in.Add(1)
+	switch {
+	case err != nil:
+		c.errors.Add(1)
+	case out > 0:
+		c.out.Add(int64(out))
+	default:
+		c.filtered.Add(1)
+	}
+}
+
+// stats snapshots the counters of every fused stage.
+func (seg *segment) stats() []StageStats {
+	stats := make([]StageStats, len(seg.counters))
+	for i := range seg.counters {
+		c := &seg.counters[i]
+		stats[i] = StageStats{
+			Stage:    StageInfo{Index: seg.first + i, Name: seg.names[i], Kind: StageElem},
+			In:       c.in.Load(),
+			Out:      c.out.Load(),
+			Filtered: c.filtered.Load(),
+			Errors:   c.errors.Load(),
+			Duration: time.Duration(c.nanos.Load()),
+		}
+	}
+	return stats
+}
+
+// observed wraps a fused segment stream with SegmentStart/SegmentEnd events.
+func (seg *segment) observed(s stream, parallel bool, o Observer) stream {
+	seg.counters = make([]stageCounters, len(seg.elemFns))
+	info := seg.info(parallel)
+	return stream{
+		n: s.n,
+		each: func(ctx context.Context, yield func(int, any) bool) error {
+			o.SegmentStart(info)
+			start := time.Now()
+			err := s.each(ctx, yield)
+			o.SegmentEnd(info, seg.stats(), time.Since(start), err)
+			return err
+		},
+	}
+}
+
+// observedBarrier runs a barrier segment with BarrierStart/BarrierEnd events.
+func (seg *segment) observedBarrier(items []any, cfg *lazyConfig) ([]any, error) {
+	info := seg.info(seg.workers(cfg) > 1 && seg.chunkSize(len(items), cfg) > 0)
+	cfg.observer.BarrierStart(info, len(items))
+	start := time.Now()
+	out, err := seg.runBarrier(items, cfg)
+	stats := StageStats{
+		Stage:    info.Stages[0],
+		In:       int64(len(items)),
+		Out:      int64(len(out)),
+		Duration: time.Since(start),
+	}
+	if err != nil {
+		stats.Errors = 1
+	}
+	cfg.observer.BarrierEnd(info, stats, err)
+	return out, err
+}
@@ -1,6 +1,9 @@
 package functional
 
-import "context"
+import (
+	"context"
+	"time"
+)
 
 // LazyOption configures the execution behavior of a LazyPipeline.
 type LazyOption func(*lazyConfig)
@@ -11,8 +14,30 @@ type lazyConfig struct {
 	chunkSize         int  // barrier chunk size; 0 = auto
 	parallelThreshold int  // below this, use sequential execution (default 1024)
 	ordered           bool // preserve order in parallel execution (default true)
+	errorMode         ErrorMode
+	deadLetter        func(context.Context, DeadLetter) // receives failed elements; nil = disabled
+	observer          Observer                          // receives execution events; nil = disabled
+	validate          bool                              // run Validate before touching any data
+	recoverPanics     bool                              // turn panics in user functions into PanicError
+	elementTimeout    time.Duration                     // deadline of each element in a fused segment; 0 = none
+	segmentWorkers    map[int]int                       // per-segment overrides of workers
+	executor          Executor                          // runs the work of parallel workers; nil = inline
+	maxInFlight       int                               // elements between dispatch and collection; 0 = unbounded
+
+	elemErrs *elemErrors // element errors collected during one execution
 }
 
+// ErrorMode controls how a LazyPipeline reacts to element-level errors.
+type ErrorMode int
+
+const (
+	// FailFast aborts the pipeline on the first element error (default).
+	FailFast ErrorMode = iota
+	// CollectAll drops failed elements and keeps processing. Every failure
+	// is reported at the end as a joined error.
+	CollectAll
+)
+
 func defaultConfig() *lazyConfig {
 	return &lazyConfig{
 		ctx:               context.Background(),
@@ -20,7 +45,18 @@ func defaultConfig() *lazyConfig {
 		chunkSize:         0,
 		parallelThreshold: 1024,
 		ordered:           true,
+		errorMode:         FailFast,
+		elemErrs:          &elemErrors{},
+	}
+}
+
+// newConfig returns a fresh config for one execution with opts applied.
+func newConfig(opts []LazyOption) *lazyConfig {
+	cfg := defaultConfig()
+	for _, opt := range opts {
+		opt(cfg)
 	}
+	return cfg
 }
 
 // WithWorkers sets the number of parallel workers for element-level stages.
@@ -31,16 +67,50 @@ func WithWorkers(n int) LazyOption {
 	}
 }
 
+// WithSegmentWorkers overrides the number of workers of the segment at
+// index seg, as numbered by Explain, e.g. to give an I/O-bound segment more
+// workers than a CPU-bound one, or to run a segment sequentially with n = 1.
+// It takes precedence over Concurrency and WithWorkers.
+func WithSegmentWorkers(seg, n int) LazyOption {
+	return func(c *lazyConfig) {
+		if c.segmentWorkers == nil {
+			c.segmentWorkers = make(map[int]int)
+		}
+		c.segmentWorkers[seg] = n
+	}
+}
+
+// WithExecutor makes parallel workers and chunked barriers run their work,
+// one job or one chunk at a time, on ex. Sharing a Pool between
+// pipelines bounds how much work they do at once in total, where each
+// pipeline alone would run up to WithWorkers elements per parallel segment.
+// WithWorkers still sets the parallelism of each segment, and sequential
+// segments run on the calling goroutine as usual.
+func WithExecutor(ex Executor) LazyOption {
+	return func(c *lazyConfig) {
+		c.executor = ex
+	}
+}
+
 // WithContext sets the context for the pipeline execution.
 // The context is checked between elements and can cancel parallel workers.
+// ElemFnCtx stages (e.g. LazyMapCtx) receive it, or a context derived from it,
+// so that a long-running call can be cancelled mid-flight.
 func WithContext(ctx context.Context) LazyOption {
 	return func(c *lazyConfig) {
 		c.ctx = ctx
 	}
 }
 
-// WithChunkSize sets the barrier chunk size for parallel execution.
-// 0 means automatic sizing.
+// WithChunkSize sets the chunk size used to split barriers created with
+// ChunkedBarrier for parallel execution. Barriers created with Barrier
+// always run once over the whole slice.
+// 0 means automatic sizing: one chunk per worker when WithWorkers(n) is set.
+//
+// It also sets the number of consecutive elements a parallel segment hands
+// to a worker at once. 0 means automatic sizing: about 8 jobs per worker of
+// up to 64 elements each; from a streamed input, idle workers take whatever
+// is available, up to 64 elements.
 func WithChunkSize(size int) LazyOption {
 	return func(c *lazyConfig) {
 		c.chunkSize = size
@@ -49,7 +119,10 @@ func WithChunkSize(size int) LazyOption {
 
 // WithParallelThreshold sets the minimum number of elements required
 // for parallel execution. Below this threshold, sequential execution is used.
-// Default is 1024.
+// Default is 1024. Segments fed by another segment compare it against the
+// most elements they can receive: the size of the pipeline input or of the
+// last barrier's output, unless a one-to-many stage such as LazyFlatMap
+// comes in between.
 func WithParallelThreshold(n int) LazyOption {
 	return func(c *lazyConfig) {
 		c.parallelThreshold = n
@@ -57,9 +130,126 @@ func WithParallelThreshold(n int) LazyOption {
 }
 
 // WithOrdered controls whether parallel execution preserves input order.
+// With WithOrdered(false), elements are emitted downstream in completion
+// order as soon as a worker finishes them, rather than with the rest of
+// their job, which lowers latency for stages whose per-element cost varies
+// (e.g. I/O-bound LazyMapWithError). Segments with batching stages still
+// emit whole jobs.
 // Default is true.
 func WithOrdered(ordered bool) LazyOption {
 	return func(c *lazyConfig) {
 		c.ordered = ordered
 	}
 }
+
+// WithValidation makes Run and Seq call LazyPipeline.Validate before
+// touching any data, so that a pipeline whose stage types do not line up
+// fails up front instead of on the first element.
+func WithValidation() LazyOption {
+	return func(c *lazyConfig) {
+		c.validate = true
+	}
+}
+
+// WithPanicRecovery turns a panic in a user function (a stage, a barrier or
+// the source sequence) into a *PanicError carrying the panic value, the
+// stack trace and the stage and element where it happened. The error is
+// then handled like any other element or barrier error: it stops the
+// pipeline and cancels the remaining workers, or goes to CollectAll and
+// the dead-letter sink.
+//
+// Without it, a panic on a worker goroutine of WithWorkers(n) crashes the
+// whole process.
+func WithPanicRecovery() LazyOption {
+	return func(c *lazyConfig) {
+		c.recoverPanics = true
+	}
+}
+
+// WithElementTimeout limits the time each element may spend in the stages
+// of a fused segment to d. Context-aware stage functions (LazyMapCtx, ...)
+// receive a context with the element's deadline. A stage call still running
+// at the deadline is abandoned, so that a slow element does not stall the
+// segment, and the element fails with a *TimeoutError, which is handled like
+// any other element error (FailFast, CollectAll or the dead-letter sink).
+//
+// The time spent downstream of the segment, e.g. by a slow Seq consumer,
+// does not count: the outputs of an element are handed on once it is done,
+// so one-to-many stages must produce all of them before the deadline.
+//
+// Abandoned calls keep running in the background until they return;
+// their results are discarded. Order-sensitive stages such as LazyScan keep
+// state between elements and are never abandoned.
+func WithElementTimeout(d time.Duration) LazyOption {
+	return func(c *lazyConfig) {
+		c.elementTimeout = d
+	}
+}
+
+// WithMaxInFlight bounds to k the number of elements of a parallel segment
+// that have been handed to a worker but not yet passed downstream, so that
+// parallel pipelines over very large inputs run in constant memory.
+// With WithOrdered(true) it acts as a reorder window: an element is only
+// dispatched within k elements of the oldest one not yet passed downstream,
+// so that a slow element holds the workers back instead of letting results
+// pile up behind it. Batching stages may then run partial batches rather
+// than wait for elements outside the window.
+// 0 (default) bounds it to two jobs per worker; see WithChunkSize for
+// their size.
+func WithMaxInFlight(k int) LazyOption {
+	return func(c *lazyConfig) {
+		c.maxInFlight = k
+	}
+}
+
+// WithErrorMode sets how element-level errors are handled.
+// With CollectAll, Run keeps processing after an ElemFn fails, drops the
+// failed element and returns the remaining results together with a joined
+// error listing every failure with its input index and stage index.
+// Elements a parallel segment processed beyond what a later stage such as
+// LazyTake consumed are not listed, nor dead-lettered, just as they would
+// not have been processed sequentially.
+// Barrier errors and context cancellation still abort the pipeline.
+// Default is FailFast.
+func WithErrorMode(mode ErrorMode) LazyOption {
+	return func(c *lazyConfig) {
+		c.errorMode = mode
+	}
+}
+
+// DeadLetter describes an element whose ElemFn failed.
+type DeadLetter struct {
+	Value any    // the element as it was passed to the failing stage
+	Index int    // position in the pipeline input (or in the output of the last barrier)
+	Stage int    // index of the failing stage in the pipeline
+	Name  string // name of the failing stage, see Named
+	Err   error  // the error returned by the stage
+}
+
+// WithDeadLetter routes elements whose ElemFn fails to fn instead of
+// aborting the pipeline. The failed element is dropped and the remaining
+// elements keep flowing through the fused segment. Dead-lettered failures
+// are not reported as errors, even with WithErrorMode(CollectAll).
+//
+// When used with WithWorkers(n), fn may be called from multiple goroutines
+// concurrently. The caller is responsible for ensuring fn is goroutine-safe.
+func WithDeadLetter(fn func(DeadLetter)) LazyOption {
+	return func(c *lazyConfig) {
+		c.deadLetter = func(_ context.Context, dl DeadLetter) { fn(dl) }
+	}
+}
+
+// WithDeadLetterChan is like WithDeadLetter but sends failed elements to ch.
+// Sending blocks until ch is ready, the pipeline context is cancelled or
+// the run stops early, e.g. when a Seq consumer breaks out of the loop; the
+// element is then dropped.
+func WithDeadLetterChan(ch chan<- DeadLetter) LazyOption {
+	return func(c *lazyConfig) {
+		c.deadLetter = func(ctx context.Context, dl DeadLetter) {
+			select {
+			case ch <- dl:
+			case <-ctx.Done():
+			}
+		}
+	}
+}
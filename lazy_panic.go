@@ -0,0 +1,128 @@
+package functional
+
+import (
+	"context"
+	"slices"
+)
+
+// recovering returns a copy of seg whose stage functions turn panics into
+// a *PanicError, for WithPanicRecovery. The shared segment is not modified.
+func (seg segment) recovering() segment {
+	if seg.barrierFn != nil {
+		fn := seg.barrierFn
+		seg.barrierFn = func(ctx context.Context, items []any) (out []any, err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					out, err = nil, recovered(r)
+				}
+			}()
+			return fn(ctx, items)
+		}
+		return seg
+	}
+
+	seg.elemFns = slices.Clone(seg.elemFns)
+	seg.flatFns = slices.Clone(seg.flatFns)
+	seg.serialFns = slices.Clone(seg.serialFns)
+	seg.batchFns = slices.Clone(seg.batchFns)
+	for i := range seg.elemFns {
+		switch {
+		case seg.elemFns[i] != nil:
+			seg.elemFns[i] = recoverElem(seg.elemFns[i])
+		case seg.flatFns[i] != nil:
+			seg.flatFns[i] = recoverFlat(seg.flatFns[i])
+		case seg.serialFns[i] != nil:
+			seg.serialFns[i] = recoverSerial(seg.serialFns[i])
+		case seg.batchFns[i] != nil:
+			seg.batchFns[i] = recoverBatch(seg.batchFns[i])
+		}
+	}
+	return seg
+}
+
+func recoverElem(fn ElemFnCtx) ElemFnCtx {
+	return func(ctx context.Context, elem any) (out any, keep bool, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				out, keep, err = nil, false, recovered(r)
+			}
+		}()
+		return fn(ctx, elem)
+	}
+}
+
+// recoverFlat recovers panics of fn itself. Panics raised while its
+// outputs are handed on (e.g. by the loop body of a Seq consumer) are not
+// the stage's and are propagated.
+func recoverFlat(fn flatFn) flatFn {
+	return func(ctx context.Context, elem any, yield func(any) bool) (err error) {
+		inYield := false
+		defer func() {
+			if r := recover(); r != nil {
+				if inYield {
+					panic(r)
+				}
+				err = recovered(r)
+			}
+		}()
+		return fn(ctx, elem, func(v any) bool {
+			inYield = true
+			more := yield(v)
+			inYield = false
+			return more
+		})
+	}
+}
+
+func recoverSerial(fn serialFn) serialFn {
+	return func() stepFn {
+		step := fn()
+		if step == nil {
+			return nil
+		}
+		return func(ctx context.Context, index int, elem any) (out any, keep, stop bool, err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					out, keep, stop, err = nil, false, false, recovered(r)
+				}
+			}()
+			return step(ctx, index, elem)
+		}
+	}
+}
+
+func recoverBatch(fn *batchFn) *batchFn {
+	return &batchFn{size: fn.size, run: func(ctx context.Context, elems []any) (out []any, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				out, err = nil, recovered(r)
+			}
+		}()
+		return fn.run(ctx, elems)
+	}}
+}
+
+// recoverStream recovers panics of the source of a pipeline, such as the
+// iter.Seq of LazyFromSeq. Like recoverFlat, it propagates panics raised
+// downstream of it.
+func recoverStream(s stream) stream {
+	each := s.each
+	s.each = func(ctx context.Context, yield func(int, any) bool) (err error) {
+		inYield := false
+		defer func() {
+			if r := recover(); r != nil {
+				if inYield {
+					panic(r)
+				}
+				err = recovered(r)
+			}
+		}()
+		return each(ctx, func(index int, v any) bool {
+			inYield = true
+			more := yield(index, v)
+			inYield = false
+			return more
+		})
+	}
+	return s
+}
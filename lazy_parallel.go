@@ -5,118 +5,404 @@ import (
 	"sync"
 )
 
-// elemResult holds the result of processing a single element with its original index.
+// elemResult holds the outputs of a job, in input order, and its failed
+// elements.
 type elemResult struct {
-	index  int
-	value  any
-	keep   bool
-	err    error
+	seq     int
+	outs    []elemOut
+	failed  []failure
+	held    int  // outputs still held by batching stages of the worker
+	partial bool // more results of the job follow, see work
 }
 
-// executeParallel runs a fused ElemFn segment using a worker pool.
-func (seg *segment) executeParallel(items []any, cfg *lazyConfig) ([]any, error) {
-	// Check context before starting any work
-	select {
-	case <-cfg.ctx.Done():
-		return nil, cfg.ctx.Err()
-	default:
+// failure is an element of a job dropped by fail, to be reported once the
+// outputs before it have been passed downstream.
+type failure struct {
+	pos int // number of outputs of the job before it
+	dl  DeadLetter
+}
+
+// elemOut is an output of a job with the index of the element it came from.
+type elemOut struct {
+	index int
+	value any
+}
+
+// add records v as an output of the element at index.
+func (r *elemResult) add(index int, v any) bool {
+	r.outs = append(r.outs, elemOut{index: index, value: v})
+	return true
+}
+
+// yield passes every output of the job to yield, and every failure to
+// report as it is reached. Once yield returns false, the failures of the
+// elements after that are dropped, as they would not have been processed
+// sequentially.
+func (r *elemResult) yield(yield func(int, any) bool, report func(DeadLetter)) bool {
+	f := 0
+	for k, o := range r.outs {
+		for ; f < len(r.failed) && r.failed[f].pos <= k; f++ {
+			report(r.failed[f].dl)
+		}
+		if !yield(o.index, o.value) {
+			return false
+		}
+	}
+	for ; f < len(r.failed); f++ {
+		report(r.failed[f].dl)
 	}
+	return true
+}
 
-	n := len(items)
+// executeParallel runs a fused ElemFn segment using a worker pool.
+// Elements are handed to seg.workers(cfg) workers in jobs of consecutive
+// elements: chunks of a materialized input, which idle workers steal from
+// busy ones, or elements of a stream pushed by a producer goroutine.
+// Results are yielded downstream as they arrive.
+func (seg *segment) executeParallel(in stream, cfg *lazyConfig) stream {
+	return stream{
+		n: -1,
+		each: func(parent context.Context, yield func(int, any) bool) error {
+			// Check context before starting any work
+			if err := parent.Err(); err != nil {
+				return err
+			}
 
-	ctx, cancel := context.WithCancel(cfg.ctx)
-	defer cancel()
+			ctx, cancel := context.WithCancel(parent)
+			defer cancel()
 
-	// Channel for dispatching work items
-	jobs := make(chan int, n)
-	results := make([]elemResult, n)
+			workers := seg.workers(cfg)
+			size := jobSize(in.n, workers, cfg)
+			win := newWindow(size, workers, cfg)
+			results := make(chan elemResult, workers)
 
-	var wg sync.WaitGroup
-	wg.Add(cfg.workers)
+			var source jobSource
+			var producerErr error
+			producerDone := make(chan struct{})
+			if in.at != nil {
+				source = newChunkQueue(in, size, workers)
+				close(producerDone)
+			} else {
+				// Producer: pull elements from upstream and queue them
+				q := newElemQueue(size, workers)
+				source = q
+				go func() {
+					defer close(producerDone)
+					defer q.close()
+					producerErr = in.each(ctx, func(index int, v any) bool {
+						return q.push(ctx, index, v)
+					})
+				}()
+			}
 
-	// Start workers
-	for w := 0; w < cfg.workers; w++ {
-		go func() {
-			defer wg.Done()
-			for idx := range jobs {
-				// Check context before processing
-				select {
-				case <-ctx.Done():
-					return
-				default:
-				}
+			// The first worker error wins and cancels everything else
+			var errOnce sync.Once
+			var workerErr error
 
-				current := items[idx]
-				keep := true
-				var err error
-				for _, fn := range seg.elemFns {
-					current, keep, err = fn(current)
-					if err != nil {
-						results[idx] = elemResult{index: idx, err: err}
+			// Start workers
+			var wg sync.WaitGroup
+			wg.Add(workers)
+			for w := 0; w < workers; w++ {
+				go func() {
+					defer wg.Done()
+					next := func() (elemJob, bool) { return source.next(ctx, w) }
+					if err := seg.work(ctx, next, win, results, cfg); err != nil {
+						// Abort in-flight elements of other workers right away
+						errOnce.Do(func() { workerErr = err })
 						cancel()
-						return
-					}
-					if !keep {
-						break
 					}
-				}
-				results[idx] = elemResult{index: idx, value: current, keep: keep}
+				}()
 			}
-		}()
+			go func() {
+				wg.Wait()
+				close(results)
+			}()
+
+			// Collect results
+			// Failures are reported on ctx, so that a blocked dead-letter
+			// sink lets go once the consumer stops
+			report := func(dl DeadLetter) { cfg.report(ctx, dl) }
+			var done bool
+			if cfg.ordered {
+				done = collectOrdered(results, win, yield, report)
+			} else {
+				done = collectUnordered(results, win, yield, report)
+			}
+			cancel()
+
+			// Drain so that every goroutine has exited before returning
+			for range results {
+			}
+			<-producerDone
+
+			if !done {
+				// The consumer stopped early: whatever failed after that,
+				// including elements aborted by the cancel, was not wanted
+				return nil
+			}
+			if workerErr != nil {
+				return workerErr
+			}
+			// Check if the user-supplied context was cancelled
+			if parent.Err() != nil {
+				return parent.Err()
+			}
+			if producerErr != nil && producerErr != context.Canceled {
+				return producerErr
+			}
+			return nil
+		},
+	}
+}
+
+// work is the loop of a single worker: it processes jobs, as win admits
+// them, until there is none left or ctx is cancelled, and returns the first
+// element error.
+func (seg *segment) work(ctx context.Context, next func() (elemJob, bool), win *window, results chan<- elemResult, cfg *lazyConfig) error {
+	if seg.batching {
+		return seg.workBatched(ctx, next, win, results, cfg)
 	}
 
-	// Dispatch jobs
-	for i := 0; i < n; i++ {
+	var r elemResult
+	dst := output{emit: r.add, job: &r}
+	var handOn func()
+	if !cfg.ordered {
+		// The outputs of every element are sent as soon as it is done, as
+		// a partial result, unless the collector is busy: the worker may be
+		// running on the executor and must not block there
+		handOn = func() {
+			if len(r.outs) == 0 && len(r.failed) == 0 {
+				return
+			}
+			part := r
+			part.partial = true
+			select {
+			case results <- part:
+				r = elemResult{seq: part.seq}
+			default:
+			}
+		}
+	}
+	for job, ok := next(); ok; job, ok = next() {
+		if ok, _ := win.enter(ctx, job.seq, nil); !ok {
+			return nil
+		}
+		// Every output of the job is collected so that
+		// they stay together and in order downstream
+		r = elemResult{seq: job.seq, outs: make([]elemOut, 0, job.len())}
+		if err := runOn(ctx, cfg, func() error { return seg.process(ctx, &job, cfg, dst, handOn) }); err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
 		select {
-		case jobs <- i:
+		case results <- r:
 		case <-ctx.Done():
-			goto done
+			return nil
 		}
 	}
-done:
-	close(jobs)
+	return nil
+}
 
-	wg.Wait()
+// workBatched is work for segments with batching stages. The worker forms
+// its own batches, so a job may be complete only after later ones have
+// been processed: its result is sent once no output is held anymore, and
+// the remaining batches are flushed when there are no more jobs, or when
+// win makes the worker wait for the results it holds back.
+func (seg *segment) workBatched(ctx context.Context, next func() (elemJob, bool), win *window, results chan<- elemResult, cfg *lazyConfig) error {
+	dst := output{batches: seg.newBatches()}
+	var pending []*elemResult
+	sendReady := func() bool {
+		held := pending[:0]
+		for _, r := range pending {
+			if r.held > 0 {
+				held = append(held, r)
+				continue
+			}
+			select {
+			case results <- *r:
+			case <-ctx.Done():
+				return false
+			}
+		}
+		clear(pending[len(held):])
+		pending = held
+		return true
+	}
 
-	// Check if the user-supplied context was cancelled
-	if cfg.ctx.Err() != nil {
-		return nil, cfg.ctx.Err()
+	flush := func() error {
+		if err := runOn(ctx, cfg, func() error { return seg.flush(ctx, cfg, dst.batches) }); err != nil {
+			return err
+		}
+		sendReady()
+		return nil
 	}
 
-	// Collect results (also detects worker errors)
-	if cfg.ordered {
-		return collectOrdered(results)
+	for job, ok := next(); ok; job, ok = next() {
+		if ok, err := win.enter(ctx, job.seq, flush); !ok {
+			return err
+		}
+		r := &elemResult{seq: job.seq, outs: make([]elemOut, 0, job.len())}
+		dst.emit, dst.job = r.add, r
+		if err := runOn(ctx, cfg, func() error { return seg.process(ctx, &job, cfg, dst, nil) }); err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		pending = append(pending, r)
+		if !sendReady() {
+			return nil
+		}
 	}
-	return collectUnordered(results)
+	if ctx.Err() != nil {
+		return nil
+	}
+	return flush()
 }
 
-// collectOrdered collects results preserving the original input order.
-func collectOrdered(results []elemResult) ([]any, error) {
-	out := make([]any, 0, len(results))
-	for _, r := range results {
-		if r.err != nil {
-			return nil, r.err
+// process runs the fused loop on every element of job, until ctx is done,
+// calling handOn, if not nil, after each of them.
+func (seg *segment) process(ctx context.Context, job *elemJob, cfg *lazyConfig, dst output, handOn func()) error {
+	for j := range job.len() {
+		if ctx.Err() != nil {
+			return nil
+		}
+		index, value := job.elem(j)
+		if _, err := seg.apply(ctx, index, value, cfg, dst); err != nil {
+			return err
 		}
-		if r.keep {
-			out = append(out, r.value)
+		if handOn != nil {
+			handOn()
 		}
 	}
-	return out, nil
+	return nil
 }
 
-// collectUnordered collects results without preserving order.
-// Since workers process in arbitrary order, results may appear in any order.
-// We still iterate by index but the effective order may differ from input
-// when elements are filtered out.
-func collectUnordered(results []elemResult) ([]any, error) {
-	out := make([]any, 0, len(results))
-	for _, r := range results {
-		if r.err != nil {
-			return nil, r.err
+// runOn calls fn on cfg.executor, or directly if there is none.
+// The executor failing to run fn (ctx is done) is reported as fn's error.
+func runOn(ctx context.Context, cfg *lazyConfig, fn func() error) error {
+	if cfg.executor == nil {
+		return fn()
+	}
+	var err error
+	if xerr := cfg.executor.Run(ctx, func() { err = fn() }); xerr != nil {
+		return xerr
+	}
+	return err
+}
+
+// collectOrdered yields results preserving the original input order,
+// reporting their failures along the way. Results arriving ahead of their
+// turn are held until the gap is filled.
+// It stops when results is closed or yield returns false, and reports
+// whether it reached the end of results.
+func collectOrdered(results <-chan elemResult, win *window, yield func(int, any) bool, report func(DeadLetter)) bool {
+	pending := make(map[int]elemResult)
+	next := 0
+	for r := range results {
+		pending[r.seq] = r
+		for {
+			p, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			next++
+			if !p.yield(yield, report) {
+				return false
+			}
+			win.done(p.seq)
 		}
-		if r.keep {
-			out = append(out, r.value)
+	}
+	return true
+}
+
+// collectUnordered yields results in completion order, as soon as each
+// worker sends them, without buffering them, reporting their failures along
+// the way. A job is done once its last, non-partial result is yielded.
+// It stops when results is closed or yield returns false, and reports
+// whether it reached the end of results.
+func collectUnordered(results <-chan elemResult, win *window, yield func(int, any) bool, report func(DeadLetter)) bool {
+	for r := range results {
+		if !r.yield(yield, report) {
+			return false
+		}
+		if !r.partial {
+			win.done(r.seq)
+		}
+	}
+	return true
+}
+
+// executeChunked runs a chunkable barrier over consecutive chunks of size
+// elements using a worker pool, concatenating the results in chunk order.
+func (seg *segment) executeChunked(items []any, size int, cfg *lazyConfig) ([]any, error) {
+	if err := cfg.ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(cfg.ctx)
+	defer cancel()
+
+	chunks := (len(items) + size - 1) / size
+	results := make([][]any, chunks)
+	errs := make([]error, chunks)
+
+	jobs := make(chan int, chunks)
+	for c := 0; c < chunks; c++ {
+		jobs <- c
+	}
+	close(jobs)
+
+	var wg sync.WaitGroup
+	workers := min(max(seg.workers(cfg), 1), chunks)
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for c := range jobs {
+				if ctx.Err() != nil {
+					return
+				}
+				start := c * size
+				end := min(start+size, len(items))
+				xerr := runOn(ctx, cfg, func() error {
+					results[c], errs[c] = seg.barrierFn(ctx, items[start:end:end])
+					return nil
+				})
+				if xerr != nil {
+					return
+				}
+				if se, ok := errs[c].(*StageError); ok && se.StageIndex < 0 && se.Index >= 0 {
+					// Report the element index in the whole slice, not in the chunk
+					se.Index += start
+				}
+				if errs[c] != nil {
+					cancel()
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Check if the user-supplied context was cancelled
+	if cfg.ctx.Err() != nil {
+		return nil, cfg.ctx.Err()
+	}
+
+	total := 0
+	for c := range results {
+		if errs[c] != nil {
+			return nil, errs[c]
 		}
+		total += len(results[c])
+	}
+	out := make([]any, 0, total)
+	for _, r := range results {
+		out = append(out, r...)
 	}
 	return out, nil
 }
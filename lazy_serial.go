@@ -0,0 +1,77 @@
+package functional
+
+import (
+	"context"
+	"time"
+)
+
+// stepFn is a single run of an order-sensitive stage. It receives the
+// element together with its index (see stream). Besides the usual output it
+// may ask the pipeline to stop: stop == true ends the run after the current
+// element (which is still emitted when keep is true).
+type stepFn func(ctx context.Context, index int, elem any) (out any, keep, stop bool, err error)
+
+// serialFn is the erased form of an order-sensitive stage. It is called once
+// per run to create fresh state, so compiled pipelines stay reusable.
+// It returns nil when the stage stops before the first element, e.g.
+// LazyTake(0), so that the segment does not read any input.
+//
+// Order-sensitive stages get a segment of their own that always runs
+// sequentially, on elements in the order they leave the previous segment.
+type serialFn func() stepFn
+
+func (fn serialFn) spec() elemSpec {
+	return elemSpec{serial: fn}
+}
+
+// executeSerial runs a segment of order-sensitive stages, one element at a
+// time. When a stage asks to stop, it stops pulling from in, which stops
+// the producer and cancels the workers of a parallel segment upstream.
+func (seg *segment) executeSerial(in stream, cfg *lazyConfig) stream {
+	return stream{
+		n: -1,
+		each: func(ctx context.Context, yield func(int, any) bool) error {
+			steps := make([]stepFn, len(seg.serialFns))
+			for i, fn := range seg.serialFns {
+				if steps[i] = fn(); steps[i] == nil {
+					// Nothing gets past stage i: do not even wait for input
+					return nil
+				}
+			}
+
+			var fnErr error
+			err := in.each(ctx, func(index int, item any) bool {
+				if fnErr = ctx.Err(); fnErr != nil {
+					return false
+				}
+
+				current := item
+				stopped := false
+				for i, step := range steps {
+					var start time.Time
+					if seg.counters != nil {
+						start = time.Now()
+					}
+					out, keep, stop, err := step(ctx, index, current)
+					if seg.counters != nil {
+						seg.record(i, time.Since(start), btoi(keep), err)
+					}
+					if err != nil {
+						fnErr = seg.fail(ctx, i, index, current, err, cfg, output{})
+						return fnErr == nil
+					}
+					stopped = stopped || stop
+					if !keep {
+						return !stopped
+					}
+					current = out
+				}
+				return yield(index, current) && !stopped
+			})
+			if fnErr != nil {
+				return fnErr
+			}
+			return err
+		},
+	}
+}
@@ -2,9 +2,14 @@ package functional
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"iter"
+	"slices"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -525,3 +530,2576 @@ func TestLazyOnce_WithOnceWith(t *testing.T) {
 	assert.Equal(t, []int{10, 20, 30}, result)
 	assert.Equal(t, 60, sum)
 }
+
+// --- Streaming Source Tests ---
+
+func TestLazyFromSeq(t *testing.T) {
+	seq := func(yield func(int) bool) {
+		for i := 1; i <= 5; i++ {
+			if !yield(i) {
+				return
+			}
+		}
+	}
+
+	result, err := LazyFromSeq[int, string](seq).
+		Elem(
+			LazyFilter[int](func(i int) bool { return i%2 == 1 }),
+			LazyMap[int, string](func(i int) string { return strconv.Itoa(i) }),
+		).
+		Run()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1", "3", "5"}, result)
+}
+
+func TestLazyFromSeq_PullsOneAtATime(t *testing.T) {
+	var events []string
+	seq := func(yield func(int) bool) {
+		for i := 1; i <= 3; i++ {
+			events = append(events, "pull "+strconv.Itoa(i))
+			if !yield(i) {
+				return
+			}
+		}
+	}
+
+	_, err := LazyFromSeq[int, int](seq).
+		Elem(LazyTap[int](func(i int) { events = append(events, "tap "+strconv.Itoa(i)) })).
+		Run()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"pull 1", "tap 1", "pull 2", "tap 2", "pull 3", "tap 3"}, events)
+}
+
+func TestLazyFromSeq_WithBarrier(t *testing.T) {
+	seq := func(yield func(int) bool) {
+		for i := 1; i <= 3; i++ {
+			if !yield(i) {
+				return
+			}
+		}
+	}
+
+	result, err := LazyFromSeq[int, int](seq).
+		Elem(LazyMap[int, int](func(i int) int { return i * 2 })).
+		Pipe(Barrier[int, int](InsertFirst(0))).
+		Elem(LazyMap[int, int](func(i int) int { return i + 1 })).
+		Run()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 3, 5, 7}, result)
+}
+
+func TestLazyFromChan(t *testing.T) {
+	ch := make(chan int)
+	go func() {
+		defer close(ch)
+		for i := 0; i < 5; i++ {
+			ch <- i
+		}
+	}()
+
+	result, err := LazyFromChan[int, int](ch).
+		Elem(LazyMap[int, int](func(i int) int { return i * 10 })).
+		Run()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0, 10, 20, 30, 40}, result)
+}
+
+func TestLazyFromChan_ContextCancellation(t *testing.T) {
+	ch := make(chan int) // never closed
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err := LazyFromChan[int, int](ch).
+		Elem(LazyMap[int, int](func(i int) int { return i })).
+		Run(WithContext(ctx))
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestLazyFromChan_Parallel(t *testing.T) {
+	ch := make(chan int)
+	go func() {
+		defer close(ch)
+		for i := 0; i < 500; i++ {
+			ch <- i
+		}
+	}()
+
+	result, err := LazyFromChan[int, int](ch).
+		Elem(LazyMap[int, int](func(i int) int { return i + 1 })).
+		Run(WithWorkers(4))
+
+	assert.NoError(t, err)
+	expected := make([]int, 500)
+	for i := range expected {
+		expected[i] = i + 1
+	}
+	assert.Equal(t, expected, result)
+}
+
+// --- Seq Tests ---
+
+func TestLazySeq(t *testing.T) {
+	var result []string
+	for v, err := range Lazy[int, string]([]int{1, 2, 3}).
+		Elem(LazyMap[int, string](func(i int) string { return strconv.Itoa(i) })).
+		Seq() {
+		assert.NoError(t, err)
+		result = append(result, v)
+	}
+
+	assert.Equal(t, []string{"1", "2", "3"}, result)
+}
+
+func TestLazySeq_Error(t *testing.T) {
+	var result []int
+	var gotErr error
+	for v, err := range Lazy[int, int]([]int{1, 2, 3}).
+		Elem(LazyMapWithError[int, int](func(i int) (int, error) {
+			if i == 3 {
+				return 0, fmt.Errorf("error at %d", i)
+			}
+			return i, nil
+		})).
+		Seq() {
+		if err != nil {
+			gotErr = err
+			continue
+		}
+		result = append(result, v)
+	}
+
+	assert.Equal(t, []int{1, 2}, result)
+	assert.ErrorContains(t, gotErr, "error at 3")
+}
+
+func TestLazySeq_BreakStopsUpstream(t *testing.T) {
+	pulled := 0
+	naturals := func(yield func(int) bool) {
+		for i := 0; ; i++ {
+			pulled++
+			if !yield(i) {
+				return
+			}
+		}
+	}
+
+	var result []int
+	for v, err := range LazyFromSeq[int, int](naturals).
+		Elem(LazyFilter[int](func(i int) bool { return i%2 == 0 })).
+		Seq() {
+		assert.NoError(t, err)
+		result = append(result, v)
+		if len(result) == 3 {
+			break
+		}
+	}
+
+	assert.Equal(t, []int{0, 2, 4}, result)
+	assert.Equal(t, 5, pulled)
+}
+
+func TestLazySeq_BreakStopsParallelWorkers(t *testing.T) {
+	var processed atomic.Int64
+	naturals := func(yield func(int) bool) {
+		for i := 0; ; i++ {
+			if !yield(i) {
+				return
+			}
+		}
+	}
+
+	count := 0
+	for _, err := range LazyFromSeq[int, int](naturals).
+		Elem(LazyTap[int](func(int) { processed.Add(1) })).
+		Seq(WithWorkers(4)) {
+		assert.NoError(t, err)
+		count++
+		if count == 10 {
+			break
+		}
+	}
+
+	// All workers have exited once the loop returns
+	after := processed.Load()
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, after, processed.Load())
+	assert.Equal(t, 10, count)
+}
+
+// --- Chunked Barrier Tests ---
+
+func TestLazyChunkedBarrier(t *testing.T) {
+	input := make([]int, 100)
+	for i := range input {
+		input[i] = i
+	}
+
+	var mu sync.Mutex
+	var chunkLens []int
+	result, err := Lazy[int, int](input).
+		Pipe(ChunkedBarrier[int, int](OnceWith[int](func(chunk []int) error {
+			mu.Lock()
+			chunkLens = append(chunkLens, len(chunk))
+			mu.Unlock()
+			return nil
+		}))).
+		Pipe(ChunkedBarrier[int, int](Map(func(i int) int { return i * 2 }))).
+		Run(WithWorkers(4), WithChunkSize(10))
+
+	assert.NoError(t, err)
+	assert.Len(t, result, 100)
+	for i, v := range result {
+		assert.Equal(t, i*2, v)
+	}
+	assert.Len(t, chunkLens, 10)
+	for _, l := range chunkLens {
+		assert.Equal(t, 10, l)
+	}
+}
+
+func TestLazyChunkedBarrier_AutoSize(t *testing.T) {
+	input := make([]int, 100)
+	for i := range input {
+		input[i] = i
+	}
+
+	var calls atomic.Int64
+	result, err := Lazy[int, int](input).
+		Pipe(ChunkedBarrier[int, int](Filter(func(i int) bool {
+			return i%2 == 0
+		}))).
+		Pipe(ChunkedBarrier[int, int](Tap(func(int) {}))).
+		Pipe(ChunkedBarrier[int, int](OnceWith[int](func([]int) error {
+			calls.Add(1)
+			return nil
+		}))).
+		Run(WithWorkers(4), WithParallelThreshold(10))
+
+	assert.NoError(t, err)
+	assert.Len(t, result, 50)
+	assert.Equal(t, 0, result[0])
+	assert.Equal(t, 98, result[49])
+	assert.Equal(t, int64(4), calls.Load())
+}
+
+func TestLazyChunkedBarrier_Error(t *testing.T) {
+	input := make([]int, 100)
+	for i := range input {
+		input[i] = i
+	}
+
+	_, err := Lazy[int, int](input).
+		Pipe(ChunkedBarrier[int, int](MapWithError(func(i int) (int, error) {
+			if i == 42 {
+				return 0, fmt.Errorf("error at %d", i)
+			}
+			return i, nil
+		}))).
+		Run(WithWorkers(4), WithChunkSize(10))
+
+	assert.ErrorContains(t, err, "error at 42")
+}
+
+func TestLazyBarrier_NotChunked(t *testing.T) {
+	input := make([]int, 100)
+	for i := range input {
+		input[i] = i
+	}
+
+	calls := 0
+	result, err := Lazy[int, int](input).
+		Pipe(Barrier[int, int](OnceWith[int](func(slice []int) error {
+			calls++
+			assert.Len(t, slice, 100)
+			return nil
+		}))).
+		Run(WithWorkers(4), WithChunkSize(10))
+
+	assert.NoError(t, err)
+	assert.Len(t, result, 100)
+	assert.Equal(t, 1, calls)
+}
+
+// --- Unordered Tests ---
+
+func TestLazyParallelUnordered(t *testing.T) {
+	input := make([]int, 200)
+	for i := range input {
+		input[i] = i
+	}
+
+	result, err := Lazy[int, int](input).
+		Elem(
+			LazyFilter[int](func(i int) bool { return i%2 == 0 }),
+			LazyMap[int, int](func(i int) int { return i + 1 }),
+		).
+		Run(WithWorkers(8), WithParallelThreshold(10), WithOrdered(false))
+
+	assert.NoError(t, err)
+	expected := make([]int, 0, 100)
+	for i := 0; i < 200; i += 2 {
+		expected = append(expected, i+1)
+	}
+	assert.ElementsMatch(t, expected, result)
+}
+
+func TestLazyParallelUnordered_EmitsInCompletionOrder(t *testing.T) {
+	input := make([]int, 20)
+	for i := range input {
+		input[i] = i
+	}
+
+	release := make(chan struct{})
+	var first int
+	for v, err := range Lazy[int, int](input).
+		Elem(LazyMap[int, int](func(i int) int {
+			if i == 0 {
+				<-release // element 0 is slow
+			}
+			return i
+		})).
+		Seq(WithWorkers(4), WithParallelThreshold(10), WithOrdered(false)) {
+		assert.NoError(t, err)
+		first = v
+		close(release)
+		break
+	}
+
+	assert.NotEqual(t, 0, first)
+}
+
+func TestLazyParallelUnordered_EmitsBeforeJobEnds(t *testing.T) {
+	// 4096 elements make jobs of 64 elements for 8 workers
+	input := make([]int, 4096)
+	for i := range input {
+		input[i] = i
+	}
+
+	start := time.Now()
+	var elapsed time.Duration
+	for _, err := range Lazy[int, int](input).
+		Elem(LazyMap[int, int](func(i int) int {
+			time.Sleep(time.Millisecond)
+			return i
+		})).
+		Seq(WithWorkers(8), WithOrdered(false)) {
+		assert.NoError(t, err)
+		elapsed = time.Since(start)
+		break
+	}
+
+	// A whole job takes 64ms
+	assert.Less(t, elapsed, 32*time.Millisecond)
+}
+
+// --- Error Mode Tests ---
+
+func TestLazyCollectAll(t *testing.T) {
+	result, err := Lazy[string, int]([]string{"1", "x", "3", "y", "5"}).
+		Elem(
+			LazyMapWithError[string, int](strconv.Atoi),
+			LazyMapWithError[int, int](func(i int) (int, error) {
+				if i == 3 {
+					return 0, fmt.Errorf("three is not allowed")
+				}
+				return i * 10, nil
+			}),
+		).
+		Run(WithErrorMode(CollectAll))
+
+	assert.Equal(t, []int{10, 50}, result)
+	assert.Error(t, err)
+	assert.ErrorContains(t, err, `stage 0, index 1: strconv.Atoi: parsing "x": invalid syntax`)
+	assert.ErrorContains(t, err, "stage 1, index 2: three is not allowed")
+	assert.ErrorContains(t, err, `stage 0, index 3: strconv.Atoi: parsing "y": invalid syntax`)
+
+	var numErr *strconv.NumError
+	assert.ErrorAs(t, err, &numErr)
+}
+
+func TestLazyCollectAll_NoErrors(t *testing.T) {
+	result, err := Lazy[int, int]([]int{1, 2, 3}).
+		Elem(LazyMapWithError[int, int](func(i int) (int, error) { return i, nil })).
+		Run(WithErrorMode(CollectAll))
+
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2, 3}, result)
+}
+
+func TestLazyCollectAll_StageIndexAcrossBarrier(t *testing.T) {
+	_, err := Lazy[int, int]([]int{1, 2, 3}).
+		Pipe(Barrier[int, int](InsertFirst(0))).
+		Elem(LazyMapWithError[int, int](func(i int) (int, error) {
+			if i == 0 {
+				return 0, fmt.Errorf("zero")
+			}
+			return i, nil
+		})).
+		Run(WithErrorMode(CollectAll))
+
+	assert.EqualError(t, err, "stage 1, index 0: zero")
+}
+
+func TestLazyCollectAll_Parallel(t *testing.T) {
+	input := make([]int, 100)
+	for i := range input {
+		input[i] = i
+	}
+
+	result, err := Lazy[int, int](input).
+		Elem(LazyMapWithError[int, int](func(i int) (int, error) {
+			if i%10 == 0 {
+				return 0, fmt.Errorf("bad %d", i)
+			}
+			return i, nil
+		})).
+		Run(WithWorkers(4), WithParallelThreshold(10), WithErrorMode(CollectAll))
+
+	assert.Len(t, result, 90)
+	// Failures are reported in input order regardless of completion order
+	expected := make([]string, 0, 10)
+	for i := 0; i < 100; i += 10 {
+		expected = append(expected, fmt.Sprintf("stage 0, index %d: bad %d", i, i))
+	}
+	assert.EqualError(t, err, strings.Join(expected, "\n"))
+}
+
+func TestLazyCollectAll_Seq(t *testing.T) {
+	var result []int
+	var gotErr error
+	for v, err := range Lazy[int, int]([]int{1, 2, 3}).
+		Elem(LazyMapWithError[int, int](func(i int) (int, error) {
+			if i == 2 {
+				return 0, fmt.Errorf("bad %d", i)
+			}
+			return i, nil
+		})).
+		Seq(WithErrorMode(CollectAll)) {
+		if err != nil {
+			gotErr = err
+			continue
+		}
+		result = append(result, v)
+	}
+
+	assert.Equal(t, []int{1, 3}, result)
+	assert.EqualError(t, gotErr, "stage 0, index 1: bad 2")
+}
+
+// --- Dead Letter Tests ---
+
+func TestLazyDeadLetter(t *testing.T) {
+	var dead []DeadLetter
+	result, err := Lazy[string, int]([]string{"1", "x", "3"}).
+		Elem(
+			LazyMapWithError[string, int](strconv.Atoi),
+			LazyMap[int, int](func(i int) int { return i * 10 }),
+		).
+		Run(WithDeadLetter(func(dl DeadLetter) { dead = append(dead, dl) }))
+
+	assert.NoError(t, err)
+	assert.Equal(t, []int{10, 30}, result)
+	assert.Len(t, dead, 1)
+	assert.Equal(t, "x", dead[0].Value)
+	assert.Equal(t, 1, dead[0].Index)
+	assert.Equal(t, 0, dead[0].Stage)
+	assert.ErrorContains(t, dead[0].Err, "invalid syntax")
+}
+
+func TestLazyDeadLetter_ValueAtFailingStage(t *testing.T) {
+	var dead []DeadLetter
+	result, err := Lazy[int, int]([]int{1, 2, 3}).
+		Elem(
+			LazyMap[int, int](func(i int) int { return i * 100 }),
+			LazyTapWithError[int](func(i int) error {
+				if i == 200 {
+					return fmt.Errorf("rejected %d", i)
+				}
+				return nil
+			}),
+		).
+		Run(WithDeadLetter(func(dl DeadLetter) { dead = append(dead, dl) }))
+
+	assert.NoError(t, err)
+	assert.Equal(t, []int{100, 300}, result)
+	assert.Equal(t, []DeadLetter{{Value: 200, Index: 1, Stage: 1, Err: dead[0].Err}}, dead)
+}
+
+func TestLazyDeadLetterChan_Parallel(t *testing.T) {
+	input := make([]int, 100)
+	for i := range input {
+		input[i] = i
+	}
+
+	ch := make(chan DeadLetter, 100)
+	result, err := Lazy[int, int](input).
+		Elem(LazyMapWithError[int, int](func(i int) (int, error) {
+			if i%25 == 0 {
+				return 0, fmt.Errorf("bad %d", i)
+			}
+			return i, nil
+		})).
+		Run(WithWorkers(4), WithParallelThreshold(10), WithDeadLetterChan(ch))
+	close(ch)
+
+	assert.NoError(t, err)
+	assert.Len(t, result, 96)
+	var indexes []int
+	for dl := range ch {
+		indexes = append(indexes, dl.Index)
+	}
+	assert.ElementsMatch(t, []int{0, 25, 50, 75}, indexes)
+}
+
+func TestLazyDeadLetterChan_SeqBreak(t *testing.T) {
+	input := make([]int, 1000)
+	for i := range input {
+		input[i] = i
+	}
+	failing := LazyMapWithError[int, int](func(i int) (int, error) {
+		if i >= 10 {
+			return 0, fmt.Errorf("bad %d", i)
+		}
+		return i, nil
+	})
+
+	for name, lp := range map[string]*LazyPipeline[int, int]{
+		"single":  Lazy[int, int](input).Stage(failing),
+		"chained": Lazy[int, int](input).Stage(failing, Concurrency(2, LazyMap[int, int](func(i int) int { return i }))),
+	} {
+		ch := make(chan DeadLetter) // never read
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for v, err := range lp.Seq(WithWorkers(4), WithParallelThreshold(1), WithOrdered(true), WithDeadLetterChan(ch)) {
+				assert.NoError(t, err)
+				assert.Equal(t, 0, v)
+				break
+			}
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: Seq did not return after break", name)
+		}
+	}
+}
+
+// --- Retry Tests ---
+
+func TestLazyRetry(t *testing.T) {
+	attempts := map[int]int{}
+	result, err := Lazy[int, int]([]int{1, 2, 3}).
+		Stage(LazyRetry(LazyMapWithError[int, int](func(i int) (int, error) {
+			attempts[i]++
+			if i == 2 && attempts[i] < 3 {
+				return 0, fmt.Errorf("transient %d", i)
+			}
+			return i * 10, nil
+		}), RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond})).
+		Run()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []int{10, 20, 30}, result)
+	assert.Equal(t, map[int]int{1: 1, 2: 3, 3: 1}, attempts)
+}
+
+func TestLazyRetry_Exhausted(t *testing.T) {
+	attempts := 0
+	_, err := Lazy[int, int]([]int{1}).
+		Stage(LazyRetry(LazyMapWithError[int, int](func(i int) (int, error) {
+			attempts++
+			return 0, fmt.Errorf("attempt %d", attempts)
+		}), RetryPolicy{MaxAttempts: 3})).
+		Run()
+
+	assert.EqualError(t, err, "stage 0, index 0: attempt 3")
+	assert.Equal(t, 3, attempts)
+}
+
+func TestLazyRetry_NotRetryable(t *testing.T) {
+	permanent := errors.New("permanent")
+	attempts := 0
+	_, err := Lazy[int, int]([]int{1}).
+		Stage(LazyRetry(LazyMapWithError[int, int](func(i int) (int, error) {
+			attempts++
+			return 0, permanent
+		}), RetryPolicy{
+			MaxAttempts: 5,
+			Retryable:   func(err error) bool { return !errors.Is(err, permanent) },
+		})).
+		Run()
+
+	assert.ErrorIs(t, err, permanent)
+	assert.Equal(t, 1, attempts)
+}
+
+func TestLazyRetry_ContextCancelledDuringBackoff(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := Lazy[int, int]([]int{1}).
+		Stage(LazyRetry(LazyMapWithError[int, int](func(i int) (int, error) {
+			return 0, fmt.Errorf("always")
+		}), RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Hour})).
+		Run(WithContext(ctx))
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestLazyRetry_RejectsSerialAndFlatStages(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3}
+	for _, fn := range []ElemStage{
+		LazyTake[int](1),
+		LazyFlatMap[int, int](func(i int) []int { return []int{i, i} }),
+	} {
+		lp := Lazy[int, int]([]int{1, 2, 3}).Stage(Named("retried", LazyRetry(fn, policy)))
+
+		var se *StageError
+		assert.ErrorAs(t, lp.Validate(), &se)
+		assert.Equal(t, 0, se.StageIndex)
+
+		_, err := lp.Run()
+		assert.EqualError(t, err, "stage 0 (retried): LazyRetry: order-sensitive and one-to-many stages cannot be retried")
+	}
+}
+
+func TestLazyRetry_BarrierCtx(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := Lazy[int, int]([]int{1}).
+		Pipe(BarrierCtx[int, int](RetryCtx(MapWithError(func(i int) (int, error) {
+			return 0, fmt.Errorf("always")
+		}), RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Hour}))).
+		Run(WithContext(ctx))
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestLazyElem_ElemFnSlice(t *testing.T) {
+	fns := []ElemFn{
+		LazyMap[int, int](func(i int) int { return i * 2 }),
+		LazyFilter[int](func(i int) bool { return i > 2 }),
+	}
+
+	result, err := Lazy[int, int]([]int{1, 2, 3}).Elem(fns...).Run()
+	assert.NoError(t, err)
+	assert.Equal(t, []int{4, 6}, result)
+
+	result, err = Define[int, int]().Elem(fns...).Compile().RunOn(context.Background(), []int{1, 2, 3})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{4, 6}, result)
+}
+
+// --- Context-aware ElemFn Tests ---
+
+func TestLazyMapCtx(t *testing.T) {
+	type key struct{}
+	ctx := context.WithValue(context.Background(), key{}, 100)
+
+	result, err := Lazy[int, int]([]int{1, 2, 3}).
+		Stage(
+			LazyFilterCtx[int](func(ctx context.Context, i int) (bool, error) { return i != 2, nil }),
+			LazyMapCtx[int, int](func(ctx context.Context, i int) (int, error) {
+				return i + ctx.Value(key{}).(int), nil
+			}),
+			LazyTapCtx[int](func(ctx context.Context, i int) error { return ctx.Err() }),
+		).
+		Run(WithContext(ctx))
+
+	assert.NoError(t, err)
+	assert.Equal(t, []int{101, 103}, result)
+}
+
+func TestLazyMapCtx_MixedWithElemFn(t *testing.T) {
+	result, err := Lazy[int, string]([]int{1, 2, 3}).
+		Stage(
+			LazyMap[int, int](func(i int) int { return i * 2 }),
+			LazyMapCtx[int, string](func(ctx context.Context, i int) (string, error) {
+				return strconv.Itoa(i), nil
+			}),
+		).
+		Run()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"2", "4", "6"}, result)
+}
+
+func TestLazyMapCtx_CancelledMidFlight(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := Lazy[int, int]([]int{1}).
+		Stage(LazyMapCtx[int, int](func(ctx context.Context, i int) (int, error) {
+			select {
+			case <-time.After(time.Hour):
+				return i, nil
+			case <-ctx.Done():
+				return 0, ctx.Err()
+			}
+		})).
+		Run(WithContext(ctx))
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestLazyMapCtx_ParallelCancelledOnFirstError(t *testing.T) {
+	input := make([]int, 100)
+	for i := range input {
+		input[i] = i
+	}
+
+	start := time.Now()
+	_, err := Lazy[int, int](input).
+		Stage(LazyMapCtx[int, int](func(ctx context.Context, i int) (int, error) {
+			if i == 0 {
+				return 0, fmt.Errorf("error at %d", i)
+			}
+			// Other elements block until the pipeline is aborted
+			select {
+			case <-time.After(time.Hour):
+				return i, nil
+			case <-ctx.Done():
+				return 0, ctx.Err()
+			}
+		})).
+		Run(WithWorkers(4), WithParallelThreshold(10))
+
+	assert.EqualError(t, err, "stage 0, index 0: error at 0")
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+// --- Observer Tests ---
+
+type recordingObserver struct {
+	NopObserver
+	mu       sync.Mutex
+	events   []string
+	segments [][]StageStats
+	barriers []StageStats
+	infos    []SegmentInfo
+}
+
+func (o *recordingObserver) SegmentStart(seg SegmentInfo) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.events = append(o.events, fmt.Sprintf("segment %d start", seg.Index))
+	o.infos = append(o.infos, seg)
+}
+
+func (o *recordingObserver) SegmentEnd(seg SegmentInfo, stats []StageStats, elapsed time.Duration, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.events = append(o.events, fmt.Sprintf("segment %d end", seg.Index))
+	o.segments = append(o.segments, stats)
+}
+
+func (o *recordingObserver) BarrierStart(seg SegmentInfo, n int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.events = append(o.events, fmt.Sprintf("barrier %d start n=%d", seg.Index, n))
+}
+
+func (o *recordingObserver) BarrierEnd(seg SegmentInfo, stats StageStats, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.events = append(o.events, fmt.Sprintf("barrier %d end", seg.Index))
+	o.barriers = append(o.barriers, stats)
+}
+
+func TestLazyObserver(t *testing.T) {
+	o := &recordingObserver{}
+	result, err := Lazy[int, int]([]int{1, 2, 3, 4, 5}).
+		Elem(
+			LazyFilter[int](func(i int) bool { return i > 1 }),
+			LazyMap[int, int](func(i int) int { return i * 10 }),
+		).
+		Pipe(Barrier[int, int](InsertFirst(0))).
+		Elem(LazyMap[int, int](func(i int) int { return i + 1 })).
+		Run(WithObserver(o))
+
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 21, 31, 41, 51}, result)
+	assert.Equal(t, []string{
+		"segment 0 start",
+		"segment 0 end",
+		"barrier 1 start n=4",
+		"barrier 1 end",
+		"segment 2 start",
+		"segment 2 end",
+	}, o.events)
+
+	// Stage 0: filter, stage 1: map
+	assert.Equal(t, StageInfo{Index: 0}, o.segments[0][0].Stage)
+	assert.Equal(t, int64(5), o.segments[0][0].In)
+	assert.Equal(t, int64(4), o.segments[0][0].Out)
+	assert.Equal(t, int64(1), o.segments[0][0].Filtered)
+	assert.Equal(t, StageInfo{Index: 1}, o.segments[0][1].Stage)
+	assert.Equal(t, int64(4), o.segments[0][1].In)
+	assert.Equal(t, int64(4), o.segments[0][1].Out)
+
+	// Stage 2: barrier
+	assert.Equal(t, StageStats{Stage: StageInfo{Index: 2, Kind: StagePipe}, In: 4, Out: 5, Duration: o.barriers[0].Duration}, o.barriers[0])
+
+	// Stage 3: map after the barrier
+	assert.Equal(t, StageInfo{Index: 3}, o.segments[1][0].Stage)
+	assert.Equal(t, int64(5), o.segments[1][0].Out)
+}
+
+func TestLazyObserver_ParallelErrors(t *testing.T) {
+	input := make([]int, 100)
+	for i := range input {
+		input[i] = i
+	}
+
+	o := &recordingObserver{}
+	_, err := Lazy[int, int](input).
+		Elem(LazyMapWithError[int, int](func(i int) (int, error) {
+			if i%10 == 0 {
+				return 0, fmt.Errorf("bad %d", i)
+			}
+			return i, nil
+		})).
+		Run(WithWorkers(4), WithParallelThreshold(10), WithErrorMode(CollectAll), WithObserver(o))
+
+	assert.Error(t, err)
+	assert.True(t, o.infos[0].Parallel)
+	stats := o.segments[0][0]
+	assert.Equal(t, int64(100), stats.In)
+	assert.Equal(t, int64(90), stats.Out)
+	assert.Equal(t, int64(10), stats.Errors)
+}
+
+// --- Explain Tests ---
+
+func TestLazyExplain(t *testing.T) {
+	lp := Lazy[int, int](make([]int, 2000)).
+		Elem(
+			LazyFilter[int](func(i int) bool { return true }),
+			LazyMap[int, int](func(i int) int { return i }),
+		).
+		Pipe(Barrier[int, int](InsertFirst(0))).
+		Once(func() error { return nil }).
+		Elem(LazyMap[int, int](func(i int) int { return i }))
+
+	assert.Equal(t, ""+
+		"Segment 0: fused loop of 2 stage(s), sequential\n"+
+		"  stage 0: Elem\n"+
+		"  stage 1: Elem\n"+
+		"Segment 1: barrier (materializes input), sequential\n"+
+		"  stage 2: Pipe\n"+
+		"Segment 2: barrier (materializes input), sequential\n"+
+		"  stage 3: Once\n"+
+		"Segment 3: fused loop of 1 stage(s), sequential\n"+
+		"  stage 4: Elem\n",
+		lp.Explain())
+
+	assert.Equal(t, ""+
+		"Segment 0: fused loop of 2 stage(s), parallel with 4 workers\n"+
+		"  stage 0: Elem\n"+
+		"  stage 1: Elem\n"+
+		"Segment 1: barrier (materializes input), sequential\n"+
+		"  stage 2: Pipe\n"+
+		"Segment 2: barrier (materializes input), sequential\n"+
+		"  stage 3: Once\n"+
+		"Segment 3: fused loop of 1 stage(s), parallel with 4 workers if input is large enough\n"+
+		"  stage 4: Elem\n",
+		lp.Explain(WithWorkers(4)))
+}
+
+func TestLazyPlan(t *testing.T) {
+	plan := Lazy[int, int]([]int{1, 2, 3}).
+		Elem(LazyMap[int, int](func(i int) int { return i })).
+		Pipe(ChunkedBarrier[int, int](Map(func(i int) int { return i }))).
+		Plan(WithWorkers(4))
+
+	assert.Len(t, plan.Segments, 2)
+	// 3 elements are below the default threshold
+	assert.False(t, plan.Segments[0].Parallel)
+	assert.False(t, plan.Segments[0].SizeDependent)
+	assert.True(t, plan.Segments[1].Barrier)
+	assert.True(t, plan.Segments[1].SizeDependent)
+	assert.Equal(t, []StageInfo{{Index: 1, Kind: StageChunkedPipe}}, plan.Segments[1].Stages)
+}
+
+func TestLazyPlan_ThresholdAfterSerial(t *testing.T) {
+	// The input of segment 1 has at most 10 elements: below the threshold
+	lp := Lazy[int, int](make([]int, 10)).
+		Stage(LazyTake[int](5)).
+		Elem(LazyMap[int, int](func(i int) int { return i }))
+
+	assert.Equal(t, ""+
+		"Segment 0: fused loop of 1 stage(s), sequential\n"+
+		"  stage 0: Elem\n"+
+		"Segment 1: fused loop of 1 stage(s), sequential\n"+
+		"  stage 1: Elem\n",
+		lp.Explain(WithWorkers(4)))
+
+	o := &recordingObserver{}
+	_, err := lp.Run(WithWorkers(4), WithObserver(o))
+	assert.NoError(t, err)
+	assert.Len(t, o.infos, 2)
+	assert.False(t, o.infos[1].Parallel)
+
+	// A one-to-many stage lifts the bound
+	lp = Lazy[int, int](make([]int, 10)).
+		Stage(LazyFlatMap[int, int](func(i int) []int { return []int{i, i} })).
+		Stage(LazyTake[int](5)).
+		Elem(LazyMap[int, int](func(i int) int { return i }))
+
+	assert.Equal(t, ""+
+		"Segment 0: fused loop of 1 stage(s), sequential\n"+
+		"  stage 0: Elem\n"+
+		"Segment 1: fused loop of 1 stage(s), sequential\n"+
+		"  stage 1: Elem\n"+
+		"Segment 2: fused loop of 1 stage(s), parallel with 4 workers\n"+
+		"  stage 2: Elem\n",
+		lp.Explain(WithWorkers(4)))
+}
+
+func TestLazyExplainAnalyze(t *testing.T) {
+	o := &recordingObserver{}
+	out, err := Lazy[int, int]([]int{1, 2, 3, 4, 5}).
+		Elem(LazyFilter[int](func(i int) bool { return i%2 == 1 })).
+		Pipe(Barrier[int, int](InsertFirst(0))).
+		ExplainAnalyze(WithObserver(o))
+
+	assert.NoError(t, err)
+	lines := strings.Split(out, "\n")
+	assert.Contains(t, lines[0], "Segment 0: fused loop of 1 stage(s), sequential [time=")
+	assert.Contains(t, lines[1], "stage 0: Elem [in=5 out=3 filtered=2 errors=0 time=")
+	assert.Contains(t, lines[2], "Segment 1: barrier (materializes input), sequential [time=")
+	assert.Contains(t, lines[3], "stage 1: Pipe [in=3 out=4 filtered=0 errors=0 time=")
+
+	// The user observer still receives events
+	assert.Len(t, o.events, 4)
+}
+
+// --- Named Stage Tests ---
+
+func TestLazyNamedStageError(t *testing.T) {
+	_, err := Lazy[string, int]([]string{"1", "2", "x"}).
+		Stage(
+			LazyFilter[string](func(s string) bool { return s != "" }),
+			Named("parse", LazyMapWithError[string, int](strconv.Atoi)),
+		).
+		Run()
+
+	var se *StageError
+	assert.ErrorAs(t, err, &se)
+	assert.Equal(t, "parse", se.Stage)
+	assert.Equal(t, 1, se.StageIndex)
+	assert.Equal(t, 2, se.Index)
+	assert.EqualError(t, err, `stage 1 (parse), index 2: strconv.Atoi: parsing "x": invalid syntax`)
+}
+
+func TestLazyNamedBarrierError(t *testing.T) {
+	_, err := Lazy[int, int]([]int{1, 2, 3}).
+		Elem(LazyMap[int, int](func(i int) int { return i })).
+		Pipe(NamedBarrier("validate", Barrier[int, int](MapWithError(func(i int) (int, error) {
+			if i == 2 {
+				return 0, fmt.Errorf("bad %d", i)
+			}
+			return i, nil
+		})))).
+		Run()
+
+	assert.EqualError(t, err, "stage 1 (validate), index 1: bad 2")
+}
+
+func TestLazyChunkedBarrierStageError(t *testing.T) {
+	input := make([]int, 100)
+	for i := range input {
+		input[i] = i
+	}
+
+	_, err := Lazy[int, int](input).
+		Pipe(ChunkedBarrier[int, int](MapWithError(func(i int) (int, error) {
+			if i == 42 {
+				return 0, fmt.Errorf("bad %d", i)
+			}
+			return i, nil
+		}))).
+		Run(WithWorkers(4), WithChunkSize(10))
+
+	assert.EqualError(t, err, "stage 0, index 42: bad 42")
+}
+
+func TestLazyNamedStage_ObserverAndExplain(t *testing.T) {
+	var dead []DeadLetter
+	o := &recordingObserver{}
+	lp := Lazy[string, int]([]string{"1", "x"}).
+		Stage(Named("parse", LazyMapWithError[string, int](strconv.Atoi))).
+		Pipe(NamedBarrier("prepend", Barrier[int, int](InsertFirst(0))))
+
+	result, err := lp.Run(WithObserver(o), WithDeadLetter(func(dl DeadLetter) { dead = append(dead, dl) }))
+
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0, 1}, result)
+	assert.Equal(t, "parse", dead[0].Name)
+	assert.Equal(t, "parse", o.infos[0].Stages[0].Name)
+	assert.Equal(t, "prepend", o.barriers[0].Stage.Name)
+	assert.Contains(t, lp.Explain(), `stage 0: Elem "parse"`)
+	assert.Contains(t, lp.Explain(), `stage 1: Pipe "prepend"`)
+}
+
+// --- Type Mismatch Tests ---
+
+func TestLazyTypeMismatch(t *testing.T) {
+	_, err := Lazy[int, int]([]int{1, 2, 3}).
+		Elem(
+			LazyMap[int, string](func(i int) string { return strconv.Itoa(i) }),
+			LazyFilter[int](func(i int) bool { return true }),
+		).
+		Run()
+
+	var tm *TypeMismatchError
+	assert.ErrorAs(t, err, &tm)
+	assert.Equal(t, &TypeMismatchError{Expected: "int", Actual: "string", Stage: 1, Index: 0}, tm)
+	assert.EqualError(t, err, "stage 1, index 0: type mismatch: expected int, got string")
+}
+
+func TestLazyTypeMismatch_Parallel(t *testing.T) {
+	input := make([]int, 100)
+	for i := range input {
+		input[i] = i
+	}
+
+	_, err := Lazy[int, int](input).
+		Elem(
+			LazyMap[int, string](func(i int) string { return strconv.Itoa(i) }),
+			LazyTap[int](func(int) {}),
+		).
+		Run(WithWorkers(4), WithParallelThreshold(10))
+
+	var tm *TypeMismatchError
+	assert.ErrorAs(t, err, &tm)
+	assert.Equal(t, 1, tm.Stage)
+}
+
+func TestLazyTypeMismatch_Barrier(t *testing.T) {
+	_, err := Lazy[int, int]([]int{1, 2, 3}).
+		Elem(LazyMap[int, string](func(i int) string { return strconv.Itoa(i) })).
+		Pipe(Barrier[int, int](InsertFirst(0))).
+		Run()
+
+	var tm *TypeMismatchError
+	assert.ErrorAs(t, err, &tm)
+	assert.Equal(t, &TypeMismatchError{Expected: "int", Actual: "string", Stage: 1, Index: 0}, tm)
+}
+
+func TestLazyTypeMismatch_BarrierOutput(t *testing.T) {
+	_, err := Lazy[int, int]([]int{1, 2, 3}).
+		Pipe(Barrier[int, int](Map(func(i int) string { return strconv.Itoa(i) }))).
+		Run()
+
+	var tm *TypeMismatchError
+	assert.ErrorAs(t, err, &tm)
+	assert.Equal(t, &TypeMismatchError{Expected: "[]int", Actual: "[]string", Stage: 0, Index: -1}, tm)
+}
+
+func TestLazyTypeMismatch_Output(t *testing.T) {
+	_, err := Lazy[int, int]([]int{1, 2, 3}).
+		Elem(LazyMap[int, any](func(i int) any {
+			if i == 2 {
+				return "two"
+			}
+			return i
+		})).
+		Run()
+
+	var tm *TypeMismatchError
+	assert.ErrorAs(t, err, &tm)
+	assert.Equal(t, &TypeMismatchError{Expected: "int", Actual: "string", Stage: -1, Index: 1}, tm)
+	assert.EqualError(t, err, "type mismatch: expected int, got string")
+}
+
+// --- Validate Tests ---
+
+func TestLazyValidate(t *testing.T) {
+	err := Lazy[int, string]([]int{1}).
+		Stage(
+			LazyFilterTyped[int](func(i int) bool { return true }),
+			LazyMapTyped[int, string](func(i int) string { return "" }),
+		).
+		Pipe(Barrier[string, string](InsertFirst(""))).
+		Once(func() error { return nil }).
+		Stage(LazyTapTyped[string](func(string) {})).
+		Validate()
+
+	assert.NoError(t, err)
+}
+
+func TestLazyValidate_StageMismatch(t *testing.T) {
+	err := Lazy[int, int]([]int{1}).
+		Stage(
+			LazyMapTyped[int, string](func(i int) string { return "" }),
+			Named("positive", LazyFilterTyped[int](func(i int) bool { return i > 0 })),
+		).
+		Validate()
+
+	var tm *TypeMismatchError
+	assert.ErrorAs(t, err, &tm)
+	assert.Equal(t, &TypeMismatchError{Expected: "int", Actual: "string", Stage: 1, Index: -1}, tm)
+	assert.EqualError(t, err, "stage 1 (positive): type mismatch: expected int, got string")
+}
+
+func TestLazyValidate_OutMismatch(t *testing.T) {
+	err := Lazy[int, int]([]int{1}).
+		Stage(Named("itoa", LazyMapTyped[int, string](func(i int) string { return "" }))).
+		Validate()
+
+	var tm *TypeMismatchError
+	assert.ErrorAs(t, err, &tm)
+	assert.Equal(t, &TypeMismatchError{Expected: "int", Actual: "string", Stage: 0, Index: -1}, tm)
+	assert.EqualError(t, err, "stage 0 (itoa): type mismatch: expected int, got string")
+}
+
+func TestLazyValidate_BarrierMismatch(t *testing.T) {
+	err := Lazy[int, int]([]int{1}).
+		Pipe(Barrier[string, int](Map(func(s string) int { return 0 }))).
+		Validate()
+
+	assert.EqualError(t, err, "stage 0: type mismatch: expected string, got int")
+}
+
+func TestLazyValidate_UnknownAndInterfaceTypes(t *testing.T) {
+	raw := ElemFn(func(elem any) (any, bool, error) { return elem, true, nil })
+
+	// The stage after a raw ElemFn is not checked
+	assert.NoError(t, Lazy[int, string]([]int{1}).
+		Stage(raw, LazyMapTyped[string, string](func(s string) string { return s })).
+		Validate())
+
+	// Concrete types are accepted by interface-typed stages, and vice versa
+	assert.NoError(t, Lazy[int, int]([]int{1}).
+		Stage(
+			LazyMapTyped[any, any](func(v any) any { return v }),
+			LazyMapTyped[int, int](func(i int) int { return i }),
+		).
+		Validate())
+}
+
+func TestLazyValidate_UntypedConstructors(t *testing.T) {
+	// LazyMap and friends still return an ElemFn, whose types are unknown
+	var double ElemFn = LazyMap[int, int](func(i int) int { return i * 2 })
+	fns := []ElemFn{double, LazyFilter[int](func(i int) bool { return i > 2 })}
+
+	assert.NoError(t, Lazy[int, string]([]int{1}).
+		Elem(fns[0], fns[1]).
+		Validate())
+
+	result, err := Lazy[int, int]([]int{1, 2, 3}).Elem(fns[0], fns[1]).Run()
+	assert.NoError(t, err)
+	assert.Equal(t, []int{4, 6}, result)
+}
+
+func TestLazyValidate_RequestExample(t *testing.T) {
+	// Untyped constructors are not checked: the mismatch only shows at run time
+	untyped := Lazy[int, int]([]int{1}).
+		Elem(
+			LazyMap[int, string](func(i int) string { return "" }),
+			LazyFilter[int](func(i int) bool { return true }),
+		)
+	assert.NoError(t, untyped.Validate())
+	_, err := untyped.Run()
+	assert.EqualError(t, err, "stage 1, index 0: type mismatch: expected int, got string")
+
+	// The typed forms are
+	err = Lazy[int, int]([]int{1}).
+		Stage(
+			LazyMapTyped[int, string](func(i int) string { return "" }),
+			LazyFilterTyped[int](func(i int) bool { return true }),
+		).
+		Validate()
+	assert.EqualError(t, err, "stage 1: type mismatch: expected int, got string")
+}
+
+func TestLazyWithValidation(t *testing.T) {
+	touched := false
+	_, err := Lazy[int, int]([]int{1, 2, 3}).
+		Stage(
+			LazyTapTyped[int](func(int) { touched = true }),
+			LazyMapTyped[string, int](func(s string) int { return 0 }),
+		).
+		Run(WithValidation())
+
+	assert.EqualError(t, err, "stage 1: type mismatch: expected string, got int")
+	assert.False(t, touched)
+}
+
+// --- Compiled Pipeline Tests ---
+
+func TestCompiledPipeline(t *testing.T) {
+	cp := Define[int, int]().
+		Elem(LazyMap[int, int](func(i int) int { return i * 2 })).
+		Pipe(Barrier[int, int](InsertLast(1))).
+		Elem(LazyFilter[int](func(i int) bool { return i > 2 })).
+		Compile()
+
+	result, err := cp.RunOn(context.Background(), []int{1, 2, 3})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{4, 6}, result)
+
+	result, err = cp.RunOn(context.Background(), []int{5})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{10}, result)
+}
+
+func TestCompiledPipeline_Branching(t *testing.T) {
+	base := Define[int, int]().Elem(LazyMap[int, int](func(i int) int { return i * 10 }))
+	base = base.Elem(LazyMap[int, int](func(i int) int { return i + 1 }))
+
+	evens := base.Elem(LazyFilter[int](func(i int) bool { return i%2 == 0 })).Compile()
+	odds := base.Elem(LazyFilter[int](func(i int) bool { return i%2 == 1 })).Compile()
+	all := base.Compile()
+
+	input := []int{1, 2, 3}
+	result, err := evens.RunOn(context.Background(), input)
+	assert.NoError(t, err)
+	assert.Empty(t, result)
+
+	result, err = odds.RunOn(context.Background(), input)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{11, 21, 31}, result)
+
+	result, err = all.RunOn(context.Background(), input)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{11, 21, 31}, result)
+}
+
+func TestCompiledPipeline_Concurrent(t *testing.T) {
+	obs := &recordingObserver{}
+	cp := Define[int, int]().
+		Elem(LazyMap[int, int](func(i int) int { return i * i })).
+		Pipe(Barrier[int, int](InsertFirst(1))).
+		Elem(LazyMap[int, int](func(i int) int { return -i })).
+		Compile()
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			input := []int{g, g + 1, g + 2}
+			result, err := cp.RunOn(context.Background(), input, WithWorkers(2), WithParallelThreshold(1), WithOrdered(true), WithObserver(obs))
+			assert.NoError(t, err)
+			assert.Equal(t, []int{-1, -g * g, -(g + 1) * (g + 1), -(g + 2) * (g + 2)}, result)
+		}()
+	}
+	wg.Wait()
+}
+
+func TestCompiledPipeline_Context(t *testing.T) {
+	cp := Define[int, int]().Elem(LazyMap[int, int](func(i int) int { return i })).Compile()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := cp.RunOn(ctx, []int{1, 2, 3}, WithContext(context.Background()))
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestCompiledPipeline_Validate(t *testing.T) {
+	assert.NoError(t, Define[int, string]().
+		Stage(LazyMapTyped[int, string](strconv.Itoa)).
+		Compile().
+		Validate())
+
+	assert.EqualError(t, Define[int, int]().
+		Stage(LazyMapTyped[string, int](func(s string) int { return 0 })).
+		Compile().
+		Validate(), "stage 0: type mismatch: expected string, got int")
+}
+
+// --- FlatMap Tests ---
+
+func TestLazyFlatMap(t *testing.T) {
+	result, err := Lazy[string, string]([]string{"a b", "", "c d e"}).
+		Stage(
+			LazyFlatMap[string, string](strings.Fields),
+			LazyMap[string, string](strings.ToUpper),
+		).
+		Run()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"A", "B", "C", "D", "E"}, result)
+}
+
+func TestLazyFlatMap_Parallel(t *testing.T) {
+	input := make([]int, 100)
+	for i := range input {
+		input[i] = i
+	}
+
+	result, err := Lazy[int, int](input).
+		Stage(
+			LazyFlatMap[int, int](func(i int) []int { return []int{i * 10, i*10 + 1} }),
+			LazyFilter[int](func(i int) bool { return i%3 != 0 }),
+		).
+		Run(WithWorkers(4), WithOrdered(true))
+
+	var expected []int
+	for _, i := range input {
+		for _, v := range []int{i * 10, i*10 + 1} {
+			if v%3 != 0 {
+				expected = append(expected, v)
+			}
+		}
+	}
+	assert.NoError(t, err)
+	assert.Equal(t, expected, result)
+}
+
+func TestLazyFlatMapSeq_StopsEarly(t *testing.T) {
+	naturals := func(int) iter.Seq[int] {
+		return func(yield func(int) bool) {
+			for i := 0; ; i++ {
+				if !yield(i) {
+					return
+				}
+			}
+		}
+	}
+
+	var got []int
+	for v, err := range Lazy[int, int]([]int{1}).Stage(LazyFlatMapSeq[int, int](naturals)).Seq() {
+		assert.NoError(t, err)
+		got = append(got, v)
+		if len(got) == 3 {
+			break
+		}
+	}
+	assert.Equal(t, []int{0, 1, 2}, got)
+}
+
+func TestLazyFlatMap_ErrorIndex(t *testing.T) {
+	_, err := Lazy[string, int]([]string{"1 2", "3 x"}).
+		Stage(
+			LazyFlatMap[string, string](strings.Fields),
+			Named("parse", LazyMapWithError[string, int](strconv.Atoi)),
+		).
+		Run()
+
+	var se *StageError
+	assert.ErrorAs(t, err, &se)
+	assert.Equal(t, 1, se.StageIndex)
+	assert.Equal(t, 1, se.Index)
+}
+
+func TestLazyFlatMap_Observer(t *testing.T) {
+	obs := &recordingObserver{}
+	_, err := Lazy[string, string]([]string{"a b", "", "c"}).
+		Stage(LazyFlatMap[string, string](strings.Fields)).
+		Run(WithObserver(obs))
+
+	assert.NoError(t, err)
+	assert.Len(t, obs.segments, 1)
+	stats := obs.segments[0][0]
+	assert.Equal(t, int64(3), stats.In)
+	assert.Equal(t, int64(3), stats.Out)
+	assert.Equal(t, int64(1), stats.Filtered)
+}
+
+// --- Take / Drop Tests ---
+
+func TestLazyTake_ShortCircuits(t *testing.T) {
+	input := make([]int, 1000)
+	for i := range input {
+		input[i] = i
+	}
+
+	pulled := 0
+	result, err := Lazy[int, int](input).
+		Stage(
+			LazyTap[int](func(int) { pulled++ }),
+			LazyFilter[int](func(i int) bool { return i%2 == 0 }),
+			LazyTake[int](3),
+		).
+		Run()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0, 2, 4}, result)
+	assert.Equal(t, 5, pulled)
+}
+
+func TestLazyTake_ZeroReadsNoInput(t *testing.T) {
+	ch := make(chan int) // never sent to, never closed
+
+	done := make(chan struct{})
+	var result []int
+	var err error
+	go func() {
+		defer close(done)
+		result, err = LazyFromChan[int, int](ch).
+			Stage(LazyTap[int](func(int) {}), LazyTake[int](0)).
+			Run(WithWorkers(4))
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("LazyTake(0) waited for input")
+	}
+	assert.NoError(t, err)
+	assert.Empty(t, result)
+}
+
+func TestLazyTakeWhile(t *testing.T) {
+	result, err := Lazy[int, int]([]int{1, 2, 3, 10, 4}).
+		Stage(LazyTakeWhile[int](func(i int) bool { return i < 5 })).
+		Run()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2, 3}, result)
+}
+
+func TestLazyDrop(t *testing.T) {
+	result, err := Lazy[int, int]([]int{1, 2, 3, 4, 5}).
+		Stage(LazyDrop[int](2), LazyLimit[int](2)).
+		Run()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []int{3, 4}, result)
+}
+
+func TestLazyDropWhile(t *testing.T) {
+	result, err := Lazy[int, int]([]int{1, 2, 10, 3, 20}).
+		Stage(LazyDropWhile[int](func(i int) bool { return i < 5 })).
+		Run()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []int{10, 3, 20}, result)
+}
+
+func TestLazyTake_Parallel(t *testing.T) {
+	input := make([]int, 1_000_000)
+	for i := range input {
+		input[i] = i
+	}
+
+	var processed atomic.Int64
+	result, err := Lazy[int, int](input).
+		Stage(
+			LazyMapCtx[int, int](func(ctx context.Context, i int) (int, error) {
+				processed.Add(1)
+				return i * 2, ctx.Err()
+			}),
+			LazyTake[int](10),
+			LazyMap[int, int](func(i int) int { return i + 1 }),
+		).
+		Run(WithWorkers(4), WithOrdered(true))
+
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 3, 5, 7, 9, 11, 13, 15, 17, 19}, result)
+	assert.Less(t, processed.Load(), int64(1000))
+}
+
+func TestLazyTake_ParallelFailures(t *testing.T) {
+	input := make([]int, 10_000)
+	for i := range input {
+		input[i] = i
+	}
+	lp := Lazy[int, int](input).
+		Stage(
+			LazyMapWithError[int, int](func(i int) (int, error) {
+				if i%3 == 2 {
+					return 0, errors.New("fail")
+				}
+				return i, nil
+			}),
+			LazyTake[int](3),
+		)
+
+	// Only the failures before the cut-off are reported, as sequentially
+	result, err := lp.Run(WithErrorMode(CollectAll))
+	assert.Equal(t, []int{0, 1, 3}, result)
+	assert.EqualError(t, err, "stage 0, index 2: fail")
+
+	result, err = lp.Run(WithErrorMode(CollectAll), WithWorkers(4), WithParallelThreshold(1), WithOrdered(true))
+	assert.Equal(t, []int{0, 1, 3}, result)
+	assert.EqualError(t, err, "stage 0, index 2: fail")
+
+	var mu sync.Mutex
+	var dead []int
+	result, err = lp.Run(WithDeadLetter(func(dl DeadLetter) {
+		mu.Lock()
+		defer mu.Unlock()
+		dead = append(dead, dl.Index)
+	}), WithWorkers(4), WithParallelThreshold(1), WithOrdered(true))
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0, 1, 3}, result)
+	assert.Equal(t, []int{2}, dead)
+}
+
+func TestLazyTake_Compiled(t *testing.T) {
+	cp := Define[int, int]().Stage(LazyTake[int](2)).Compile()
+
+	for range 3 {
+		result, err := cp.RunOn(context.Background(), []int{1, 2, 3})
+		assert.NoError(t, err)
+		assert.Equal(t, []int{1, 2}, result)
+	}
+}
+
+func TestLazyTake_Explain(t *testing.T) {
+	explain := Lazy[int, int]([]int{1, 2, 3}).
+		Stage(
+			LazyMap[int, int](func(i int) int { return i }),
+			LazyTake[int](2),
+		).
+		Explain(WithWorkers(4), WithParallelThreshold(1))
+
+	assert.Equal(t, "Segment 0: fused loop of 1 stage(s), parallel with 4 workers\n"+
+		"  stage 0: Elem\n"+
+		"Segment 1: fused loop of 1 stage(s), sequential\n"+
+		"  stage 1: Elem\n", explain)
+}
+
+// --- Stateful Stage Tests ---
+
+func TestLazyDistinct(t *testing.T) {
+	result, err := Lazy[int, int]([]int{3, 1, 3, 2, 1, 4}).
+		Stage(LazyDistinct[int]()).
+		Run()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []int{3, 1, 2, 4}, result)
+}
+
+func TestLazyDistinctBy_Parallel(t *testing.T) {
+	input := make([]string, 1000)
+	for i := range input {
+		input[i] = strconv.Itoa(i)
+	}
+
+	result, err := Lazy[string, string](input).
+		Stage(
+			LazyMap[string, string](func(s string) string { return s[:1] }),
+			LazyDistinctBy[string, string](func(s string) string { return s }),
+		).
+		Run(WithWorkers(4), WithOrdered(true))
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9"}, result)
+}
+
+func TestLazyScan(t *testing.T) {
+	input := make([]int, 100)
+	expected := make([]int, 100)
+	sum := 0
+	for i := range input {
+		input[i] = i
+		sum += i
+		expected[i] = sum
+	}
+
+	result, err := Lazy[int, int](input).
+		Stage(LazyScan(0, func(acc, n int) int { return acc + n })).
+		Run(WithWorkers(4), WithOrdered(true))
+
+	assert.NoError(t, err)
+	assert.Equal(t, expected, result)
+}
+
+func TestLazyWithIndex(t *testing.T) {
+	result, err := Lazy[string, Pair[int, string]]([]string{"a", "", "b", "c"}).
+		Stage(
+			LazyFilter[string](func(s string) bool { return s != "" }),
+			LazyWithIndex[string](),
+		).
+		Run(WithWorkers(2), WithParallelThreshold(1))
+
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []Pair[int, string]{{0, "a"}, {2, "b"}, {3, "c"}}, result)
+}
+
+func TestLazyStatefulStages_Compiled(t *testing.T) {
+	cp := Define[int, int]().
+		Stage(
+			LazyDistinct[int](),
+			LazyScan(0, func(acc, n int) int { return acc + n }),
+		).
+		Compile()
+
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := cp.RunOn(context.Background(), []int{1, 1, 2, 2, 3})
+			assert.NoError(t, err)
+			assert.Equal(t, []int{1, 3, 6}, result)
+		}()
+	}
+	wg.Wait()
+}
+
+func TestLazyScan_Validate(t *testing.T) {
+	assert.NoError(t, Lazy[string, int]([]string{"a"}).
+		Stage(LazyScan(0, func(acc int, s string) int { return acc + len(s) })).
+		Validate())
+}
+
+// --- Batch Map Tests ---
+
+func TestLazyBatchMap(t *testing.T) {
+	var sizes []int
+	result, err := Lazy[int, string]([]int{1, 2, 3, 4, 5, 6, 7, 8}).
+		Stage(
+			LazyFilter[int](func(i int) bool { return i != 4 }),
+			LazyBatchMap[int, int](3, func(batch []int) ([]int, error) {
+				sizes = append(sizes, len(batch))
+				out := make([]int, len(batch))
+				for i, v := range batch {
+					out[i] = v * 10
+				}
+				return out, nil
+			}),
+			LazyMap[int, string](strconv.Itoa),
+		).
+		Run()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10", "20", "30", "50", "60", "70", "80"}, result)
+	assert.Equal(t, []int{3, 3, 1}, sizes)
+}
+
+func TestLazyBatchMap_Parallel(t *testing.T) {
+	input := make([]int, 1000)
+	for i := range input {
+		input[i] = i
+	}
+
+	var calls atomic.Int64
+	result, err := Lazy[int, int](input).
+		Stage(
+			LazyBatchMap[int, int](16, func(batch []int) ([]int, error) {
+				calls.Add(1)
+				if len(batch) > 16 {
+					return nil, errors.New("batch too large")
+				}
+				out := make([]int, len(batch))
+				for i, v := range batch {
+					out[i] = v + 1
+				}
+				return out, nil
+			}),
+			LazyFilter[int](func(i int) bool { return i%2 == 0 }),
+		).
+		Run(WithWorkers(4), WithOrdered(true))
+
+	var expected []int
+	for _, i := range input {
+		if (i+1)%2 == 0 {
+			expected = append(expected, i+1)
+		}
+	}
+	assert.NoError(t, err)
+	assert.Equal(t, expected, result)
+	assert.GreaterOrEqual(t, calls.Load(), int64(1000/16))
+	assert.Less(t, calls.Load(), int64(1000))
+}
+
+func TestLazyBatchMap_Error(t *testing.T) {
+	_, err := Lazy[int, int]([]int{1, 2, 3, 4, 5}).
+		Stage(
+			LazyMap[int, int](func(i int) int { return i }),
+			Named("lookup", LazyBatchMap[int, int](2, func(batch []int) ([]int, error) {
+				if batch[0] == 3 {
+					return nil, errors.New("lookup failed")
+				}
+				return batch, nil
+			})),
+		).
+		Run()
+
+	assert.EqualError(t, err, "stage 1 (lookup), index 2: lookup failed")
+}
+
+func TestLazyBatchMap_CollectAll(t *testing.T) {
+	result, err := Lazy[int, int]([]int{1, 2, 3, 4, 5}).
+		Stage(LazyBatchMap[int, int](2, func(batch []int) ([]int, error) {
+			if batch[0] == 3 {
+				return nil, errors.New("lookup failed")
+			}
+			return batch, nil
+		})).
+		Run(WithErrorMode(CollectAll))
+
+	assert.Equal(t, []int{1, 2, 5}, result)
+	assert.EqualError(t, err, "stage 0, index 2: lookup failed\nstage 0, index 3: lookup failed")
+}
+
+func TestLazyBatchMap_ResultCount(t *testing.T) {
+	_, err := Lazy[int, int]([]int{1, 2, 3}).
+		Stage(LazyBatchMap[int, int](2, func(batch []int) ([]int, error) {
+			return batch[:1], nil
+		})).
+		Run()
+
+	assert.EqualError(t, err, "stage 0, index 0: Lazy: batch of 2 elements returned 1 results")
+}
+
+func TestLazyBatchMap_SeqStopsEarly(t *testing.T) {
+	var calls int
+	var got []int
+	for v, err := range Lazy[int, int]([]int{1, 2, 3, 4, 5}).
+		Stage(LazyBatchMap[int, int](2, func(batch []int) ([]int, error) {
+			calls++
+			return batch, nil
+		})).
+		Seq() {
+		assert.NoError(t, err)
+		got = append(got, v)
+		if len(got) == 1 {
+			break
+		}
+	}
+	assert.Equal(t, []int{1}, got)
+	assert.Equal(t, 1, calls)
+}
+
+func TestLazyBatchMap_ParallelFlatMap(t *testing.T) {
+	input := make([]int, 200)
+	for i := range input {
+		input[i] = i
+	}
+	double := func(batch []int) ([]int, error) {
+		out := make([]int, len(batch))
+		for i, v := range batch {
+			out[i] = v * 2
+		}
+		return out, nil
+	}
+
+	result, err := Lazy[int, int](input).
+		Stage(
+			LazyBatchMap[int, int](7, double),
+			LazyFlatMap[int, int](func(i int) []int { return []int{i, i + 1} }),
+			LazyBatchMap[int, int](5, double),
+		).
+		Run(WithWorkers(3))
+
+	var expected []int
+	for _, i := range input {
+		expected = append(expected, i*4, (i*2+1)*2)
+	}
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, expected, result)
+}
+
+// --- Built-in Barrier Tests ---
+
+func TestLazyBuiltinBarriers(t *testing.T) {
+	result, err := Lazy[int, Pair[bool, []int]]([]int{5, 3, 8, 1, 4, 7, 2, 6}).
+		Pipe(SortBy(func(a, b int) int { return a - b })).
+		Elem(LazyMap[int, int](func(i int) int { return i * 10 })).
+		Pipe(
+			Reverse[int](),
+			TopK(6, func(a, b int) bool { return a < b }),
+			GroupBy(func(i int) bool { return i > 50 }),
+		).
+		Run()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []Pair[bool, []int]{
+		{Key: true, Value: []int{80, 70, 60}},
+		{Key: false, Value: []int{50, 40, 30}},
+	}, result)
+}
+
+func TestLazyChunkBarrier(t *testing.T) {
+	result, err := Lazy[int, int]([]int{1, 2, 3, 4, 5}).
+		Pipe(Chunk[int](2)).
+		Elem(LazyMap[[]int, int](func(c []int) int { return len(c) })).
+		Run(WithWorkers(4), WithOrdered(true))
+
+	assert.NoError(t, err)
+	assert.Equal(t, []int{2, 2, 1}, result)
+}
+
+func TestLazyBuiltinBarrier_Wrapped(t *testing.T) {
+	result, err := Lazy[int, int]([]int{1, 2, 3}).
+		Pipe(Barrier[int, int](Reverse[int]().PipeFn()), NamedBarrier("shuffle", Shuffle[int](1))).
+		Run()
+
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []int{1, 2, 3}, result)
+}
+
+func TestLazyBuiltinBarrier_Errors(t *testing.T) {
+	_, err := Lazy[int, int]([]int{1, 2}).
+		Pipe(SortBy(func(a, b string) int { return 0 })).
+		Run()
+	assert.EqualError(t, err, "stage 0, index 0: type mismatch: expected string, got int")
+}
+
+func TestLazyBuiltinBarrier_Validate(t *testing.T) {
+	assert.NoError(t, Lazy[int, []int]([]int{1, 2}).
+		Pipe(SortBy(func(a, b int) int { return a - b }), Chunk[int](2)).
+		Validate())
+
+	assert.EqualError(t, Lazy[int, int]([]int{1, 2}).
+		Pipe(Reverse[int](), SortBy(func(a, b string) int { return 0 })).
+		Validate(), "stage 1: type mismatch: expected string, got int")
+
+	assert.EqualError(t, Lazy[int, int]([]int{1, 2}).
+		Pipe(GroupBy(func(i int) bool { return i > 1 })).
+		Validate(), "stage 0: type mismatch: expected int, got functional.Pair[bool,[]int]")
+}
+
+// --- Panic Recovery Tests ---
+
+func TestLazyPanicRecovery_Parallel(t *testing.T) {
+	input := make([]int, 100)
+	for i := range input {
+		input[i] = i
+	}
+
+	_, err := Lazy[int, int](input).
+		Elem(LazyMap[int, int](func(i int) int {
+			if i == 42 {
+				panic("boom")
+			}
+			return i
+		})).
+		Run(WithWorkers(4), WithPanicRecovery())
+
+	assert.EqualError(t, err, "stage 0, index 42: panic: boom")
+	var pe *PanicError
+	assert.ErrorAs(t, err, &pe)
+	assert.Equal(t, "boom", pe.Value)
+	assert.Equal(t, 0, pe.Stage)
+	assert.Equal(t, 42, pe.Index)
+	assert.NotEmpty(t, pe.Stack)
+}
+
+func TestLazyPanicRecovery_CollectAll(t *testing.T) {
+	result, err := Lazy[int, int]([]int{1, 2, 3}).
+		Stage(
+			LazyMap[int, int](func(i int) int { return i }),
+			LazyFlatMapSeq[int, int](func(i int) iter.Seq[int] {
+				return func(yield func(int) bool) {
+					if i == 2 {
+						panic(errors.New("bad element"))
+					}
+					yield(i)
+				}
+			}),
+		).
+		Run(WithErrorMode(CollectAll), WithPanicRecovery())
+
+	assert.Equal(t, []int{1, 3}, result)
+	assert.EqualError(t, err, "stage 1, index 1: panic: bad element")
+}
+
+func TestLazyPanicRecovery_Barrier(t *testing.T) {
+	_, err := Lazy[int, int]([]int{1, 2, 3}).
+		Pipe(NamedBarrier("explode", Barrier[int, int](func(any) (any, error) { panic("barrier") }))).
+		Run(WithPanicRecovery())
+
+	assert.EqualError(t, err, "stage 0 (explode): panic: barrier")
+	var pe *PanicError
+	assert.ErrorAs(t, err, &pe)
+	assert.Equal(t, -1, pe.Index)
+}
+
+func TestLazyPanicRecovery_Source(t *testing.T) {
+	seq := func(yield func(int) bool) {
+		yield(1)
+		panic("source")
+	}
+
+	_, err := LazyFromSeq[int, int](seq).
+		Elem(LazyMap[int, int](func(i int) int { return i })).
+		Run(WithWorkers(2), WithPanicRecovery())
+
+	var pe *PanicError
+	assert.ErrorAs(t, err, &pe)
+	assert.Equal(t, "source", pe.Value)
+}
+
+func TestLazyPanicRecovery_ConsumerPanicPropagates(t *testing.T) {
+	assert.PanicsWithValue(t, "consumer", func() {
+		for range Lazy[int, int]([]int{1, 2}).
+			Stage(LazyFlatMap[int, int](func(i int) []int { return []int{i} })).
+			Seq(WithPanicRecovery()) {
+			panic("consumer")
+		}
+	})
+}
+
+// --- Timeout Tests ---
+
+func TestLazyTimeout(t *testing.T) {
+	start := time.Now()
+	_, err := Lazy[int, int]([]int{1, 2, 3}).
+		Stage(Named("slow", LazyTimeout(20*time.Millisecond, LazyMap[int, int](func(i int) int {
+			if i == 2 {
+				time.Sleep(time.Second)
+			}
+			return i
+		})))).
+		Run()
+
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+	assert.EqualError(t, err, "stage 0 (slow), index 1: timed out after 20ms")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	var te *TimeoutError
+	assert.ErrorAs(t, err, &te)
+	assert.Equal(t, 0, te.Stage)
+	assert.Equal(t, 1, te.Index)
+}
+
+func TestLazyTimeout_OrderSensitive(t *testing.T) {
+	lp := Lazy[int, int]([]int{1, 2, 3}).
+		Stage(Named("sum", LazyTimeout(time.Second, LazyScan(0, func(acc, i int) int { return acc + i }))))
+
+	var se *StageError
+	assert.ErrorAs(t, lp.Validate(), &se)
+	assert.Equal(t, 0, se.StageIndex)
+
+	_, err := lp.Run()
+	assert.EqualError(t, err, "stage 0 (sum): LazyTimeout: order-sensitive stages cannot time out")
+}
+
+func TestLazyElementTimeout_Ctx(t *testing.T) {
+	result, err := Lazy[int, int]([]int{1, 2, 3}).
+		Stage(LazyMapCtx[int, int](func(ctx context.Context, i int) (int, error) {
+			if _, ok := ctx.Deadline(); !ok {
+				return 0, errors.New("no deadline")
+			}
+			if i == 3 {
+				<-ctx.Done()
+				return 0, ctx.Err()
+			}
+			return i, nil
+		})).
+		Run(WithElementTimeout(20*time.Millisecond), WithErrorMode(CollectAll))
+
+	assert.Equal(t, []int{1, 2}, result)
+	assert.EqualError(t, err, "stage 0, index 2: timed out after 20ms")
+}
+
+func TestLazyElementTimeout_AcrossStages(t *testing.T) {
+	// Every stage gets the same deadline: that of the element
+	var deadline time.Time
+	_, err := Lazy[int, int]([]int{1}).
+		Stage(
+			LazyMapCtx[int, int](func(ctx context.Context, i int) (int, error) {
+				deadline, _ = ctx.Deadline()
+				return i, nil
+			}),
+			LazyMapCtx[int, int](func(ctx context.Context, i int) (int, error) {
+				if d, _ := ctx.Deadline(); !d.Equal(deadline) {
+					return 0, errors.New("deadline reset")
+				}
+				<-ctx.Done()
+				return 0, ctx.Err()
+			}),
+		).
+		Run(WithElementTimeout(20 * time.Millisecond))
+
+	var te *TimeoutError
+	assert.ErrorAs(t, err, &te)
+	assert.Equal(t, 1, te.Stage)
+}
+
+func TestLazyTimeout_FlatMapSlowConsumer(t *testing.T) {
+	lp := Lazy[int, int]([]int{1, 2}).
+		Stage(LazyTimeout(20*time.Millisecond, LazyFlatMap[int, int](func(i int) []int {
+			return []int{i, i * 10, i * 100}
+		})))
+
+	// Only the expansion itself counts against the timeout
+	var result []int
+	for v, err := range lp.Seq() {
+		assert.NoError(t, err)
+		result = append(result, v)
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, []int{1, 10, 100, 2, 20, 200}, result)
+
+	result = nil
+	for v, err := range lp.Seq(WithElementTimeout(20 * time.Millisecond)) {
+		assert.NoError(t, err)
+		result = append(result, v)
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, []int{1, 10, 100, 2, 20, 200}, result)
+}
+
+func TestLazyTimeout_FlatMapSeqEndless(t *testing.T) {
+	_, err := Lazy[int, int]([]int{1}).
+		Stage(LazyTimeout(20*time.Millisecond, LazyFlatMapSeq[int, int](func(i int) iter.Seq[int] {
+			return func(yield func(int) bool) {
+				for yield(i) {
+				}
+			}
+		}))).
+		Run()
+
+	var te *TimeoutError
+	assert.ErrorAs(t, err, &te)
+	assert.Equal(t, 0, te.Index)
+}
+
+func TestLazyElementTimeout_DeadLetterParallel(t *testing.T) {
+	input := make([]int, 20)
+	for i := range input {
+		input[i] = i
+	}
+
+	var mu sync.Mutex
+	var dead []int
+	result, err := Lazy[int, int](input).
+		Elem(LazyMap[int, int](func(i int) int {
+			if i%5 == 0 {
+				time.Sleep(time.Second)
+			}
+			return i
+		})).
+		Run(
+			WithWorkers(4),
+			WithElementTimeout(20*time.Millisecond),
+			WithDeadLetter(func(dl DeadLetter) {
+				mu.Lock()
+				defer mu.Unlock()
+				dead = append(dead, dl.Index)
+				assert.ErrorIs(t, dl.Err, context.DeadlineExceeded)
+			}),
+		)
+
+	assert.NoError(t, err)
+	assert.Len(t, result, 16)
+	assert.ElementsMatch(t, []int{0, 5, 10, 15}, dead)
+}
+
+// --- Rate Limit and Concurrency Tests ---
+
+func TestLazyRateLimit(t *testing.T) {
+	start := time.Now()
+	result, err := Lazy[int, int]([]int{1, 2, 3, 4, 5}).
+		Stage(LazyRateLimit(20, 1)).
+		Run(WithWorkers(4))
+
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []int{1, 2, 3, 4, 5}, result)
+	assert.GreaterOrEqual(t, time.Since(start), 150*time.Millisecond)
+}
+
+func TestLazyRateLimit_Burst(t *testing.T) {
+	start := time.Now()
+	_, err := Lazy[int, int]([]int{1, 2, 3}).
+		Stage(LazyRateLimit(1, 3)).
+		Run()
+
+	assert.NoError(t, err)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+}
+
+func TestLazyRateLimit_Cancel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	_, err := Lazy[int, int]([]int{1, 2, 3}).
+		Stage(LazyRateLimit(0.1, 1)).
+		Run(WithContext(ctx))
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestLazyConcurrency(t *testing.T) {
+	input := make([]int, 50)
+	for i := range input {
+		input[i] = i
+	}
+
+	var inFlight, peak atomic.Int64
+	call := LazyMap[int, int](func(i int) int {
+		n := inFlight.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		inFlight.Add(-1)
+		return i * 2
+	})
+
+	result, err := Lazy[int, int](input).
+		Stage(LazyMap[int, int](func(i int) int { return i }), Concurrency(2, call)).
+		Run(WithWorkers(8), WithOrdered(true))
+
+	expected := make([]int, len(input))
+	for i := range input {
+		expected[i] = i * 2
+	}
+	assert.NoError(t, err)
+	assert.Equal(t, expected, result)
+	assert.LessOrEqual(t, peak.Load(), int64(2))
+}
+
+func TestLazyConcurrency_Explain(t *testing.T) {
+	call := LazyMap[int, int](func(i int) int { return i })
+	lp := Lazy[int, int]([]int{1, 2, 3}).
+		Elem(call).
+		Stage(Concurrency(2, LazyRateLimit(100, 1), call))
+
+	assert.Equal(t, "Segment 0: fused loop of 1 stage(s), parallel with 8 workers\n"+
+		"  stage 0: Elem\n"+
+		"Segment 1: fused loop of 2 stage(s), parallel with 2 workers\n"+
+		"  stage 1: Elem\n"+
+		"  stage 2: Elem\n", lp.Explain(WithWorkers(8), WithParallelThreshold(1)))
+
+	assert.Equal(t, "Segment 0: fused loop of 1 stage(s), sequential\n"+
+		"  stage 0: Elem\n"+
+		"Segment 1: fused loop of 2 stage(s), parallel with 4 workers\n"+
+		"  stage 1: Elem\n"+
+		"  stage 2: Elem\n", lp.Explain(WithWorkers(8), WithParallelThreshold(1), WithSegmentWorkers(0, 1), WithSegmentWorkers(1, 4)))
+}
+
+func TestLazyConcurrency_Wrapped(t *testing.T) {
+	failed := map[int]bool{}
+	var mu sync.Mutex
+	call := LazyMapWithError[int, int](func(i int) (int, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if !failed[i] {
+			failed[i] = true
+			return 0, errors.New("transient")
+		}
+		return i * 2, nil
+	})
+	parse := LazyMapTyped[string, int](func(s string) int { n, _ := strconv.Atoi(s); return n })
+
+	lp := Lazy[string, int]([]string{"1", "2", "3"}).
+		Stage(parse, Named("call", LazyRetry(Concurrency(2, LazyRateLimit(1000, 1), call), RetryPolicy{MaxAttempts: 2})))
+
+	result, err := lp.Run(WithWorkers(4), WithParallelThreshold(1), WithOrdered(true))
+	assert.NoError(t, err)
+	assert.Equal(t, []int{2, 4, 6}, result)
+	assert.Equal(t, "Segment 0: fused loop of 1 stage(s), parallel with 4 workers\n"+
+		"  stage 0: Elem\n"+
+		"Segment 1: fused loop of 2 stage(s), parallel with 2 workers\n"+
+		"  stage 1: Elem \"call\"\n"+
+		"  stage 2: Elem \"call\"\n", lp.Explain(WithWorkers(4), WithParallelThreshold(1)))
+}
+
+func TestLazyConcurrency_Caps(t *testing.T) {
+	lp := Lazy[int, int]([]int{1, 2, 3}).
+		Stage(Concurrency(4, LazyMap[int, int](func(i int) int { return i })))
+
+	// Concurrency only lowers the number of workers
+	assert.Equal(t, "Segment 0: fused loop of 1 stage(s), sequential\n"+
+		"  stage 0: Elem\n", lp.Explain(WithParallelThreshold(1)))
+	assert.Equal(t, "Segment 0: fused loop of 1 stage(s), parallel with 2 workers\n"+
+		"  stage 0: Elem\n", lp.Explain(WithWorkers(2), WithParallelThreshold(1)))
+	assert.Equal(t, "Segment 0: fused loop of 1 stage(s), parallel with 4 workers\n"+
+		"  stage 0: Elem\n", lp.Explain(WithWorkers(8), WithParallelThreshold(1)))
+}
+
+func TestLazyRateLimit_Validate(t *testing.T) {
+	assert.NoError(t, Lazy[int, string]([]int{1}).
+		Stage(LazyRateLimit(10, 1), LazyMapTyped[int, string](strconv.Itoa)).
+		Validate())
+
+	assert.Error(t, Lazy[int, string]([]int{1}).
+		Stage(LazyRateLimit(10, 1), LazyMapTyped[string, string](strings.ToUpper)).
+		Validate())
+}
+
+// --- Executor Tests ---
+
+func TestLazyExecutor_SharedPool(t *testing.T) {
+	input := make([]int, 40)
+	expected := make([]int, len(input))
+	for i := range input {
+		input[i] = i
+		expected[i] = i + 1
+	}
+
+	var inFlight, peak atomic.Int64
+	call := LazyMap[int, int](func(i int) int {
+		n := inFlight.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		inFlight.Add(-1)
+		return i + 1
+	})
+
+	pool := NewPool(3)
+	var wg sync.WaitGroup
+	for p := 0; p < 4; p++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := Lazy[int, int](input).
+				Elem(call).
+				Run(WithWorkers(8), WithParallelThreshold(1), WithExecutor(pool))
+			assert.NoError(t, err)
+			assert.Equal(t, expected, result)
+		}()
+	}
+	wg.Wait()
+
+	assert.LessOrEqual(t, peak.Load(), int64(3))
+	assert.Equal(t, PoolStats{Size: 3, Completed: 4 * 40}, pool.Stats())
+}
+
+func TestLazyExecutor_ChainedSegments(t *testing.T) {
+	// A pool smaller than the workers of chained parallel segments
+	// must not deadlock
+	input := make([]int, 100)
+	for i := range input {
+		input[i] = i
+	}
+
+	result, err := Lazy[int, int](input).
+		Elem(LazyMap[int, int](func(i int) int { return i * 2 })).
+		Stage(Concurrency(4, LazyMap[int, int](func(i int) int { return i + 1 }))).
+		Stage(LazyBatchMap[int, int](7, func(b []int) ([]int, error) { return b, nil })).
+		Run(WithWorkers(8), WithParallelThreshold(1), WithExecutor(NewPool(1)))
+
+	expected := make([]int, len(input))
+	for i := range input {
+		expected[i] = i*2 + 1
+	}
+	assert.NoError(t, err)
+	assert.Equal(t, expected, result)
+}
+
+func TestLazyExecutor_ChunkedBarrier(t *testing.T) {
+	input := []int{5, 3, 8, 1, 9, 2}
+	pool := NewPool(2)
+
+	result, err := Lazy[int, int](input).
+		Pipe(ChunkedBarrier[int, int](Map(func(i int) int { return i * 2 }))).
+		Run(WithWorkers(3), WithChunkSize(2), WithExecutor(pool))
+
+	assert.NoError(t, err)
+	assert.Equal(t, []int{10, 6, 16, 2, 18, 4}, result)
+	assert.Equal(t, int64(3), pool.Stats().Completed)
+}
+
+func TestLazyExecutor_Cancel(t *testing.T) {
+	pool := NewPool(1)
+	ctx, cancel := context.WithCancel(context.Background())
+	release := make(chan struct{})
+
+	// Occupy the pool so that the pipeline's workers wait in the queue
+	go pool.Run(context.Background(), func() { <-release })
+	defer close(release)
+	assert.Eventually(t, func() bool { return pool.Stats().Active == 1 }, time.Second, time.Millisecond)
+
+	go func() {
+		assert.Eventually(t, func() bool { return pool.Stats().Queued > 0 }, time.Second, time.Millisecond)
+		cancel()
+	}()
+	_, err := Lazy[int, int]([]int{1, 2, 3}).
+		Elem(LazyMap[int, int](func(i int) int { return i })).
+		Run(WithWorkers(2), WithParallelThreshold(1), WithExecutor(pool), WithContext(ctx))
+
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+// --- Parallel Dispatch Tests ---
+
+func TestLazyParallel_ChunkSizes(t *testing.T) {
+	input := make([]int, 100)
+	expected := []int{}
+	for i := range input {
+		input[i] = i
+		if i%3 != 0 {
+			expected = append(expected, i*10, i*10+1)
+		}
+	}
+
+	lp := Lazy[int, int](input).Stage(
+		LazyFilter[int](func(i int) bool { return i%3 != 0 }),
+		LazyFlatMap[int, int](func(i int) []int { return []int{i * 10, i*10 + 1} }),
+	)
+	for _, size := range []int{0, 1, 3, 7, 64, 1000} {
+		result, err := lp.Run(WithWorkers(4), WithParallelThreshold(1), WithChunkSize(size))
+		assert.NoError(t, err)
+		assert.Equal(t, expected, result, "chunk size %d", size)
+	}
+}
+
+func TestLazyParallel_StreamJobs(t *testing.T) {
+	input := make([]int, 500)
+	expected := []int{}
+	for i := range input {
+		input[i] = i
+		if i%2 == 0 {
+			expected = append(expected, i+1)
+		}
+	}
+
+	for _, size := range []int{0, 1, 5} {
+		result, err := LazyFromSeq[int, int](slices.Values(input)).
+			Stage(
+				LazyFilter[int](func(i int) bool { return i%2 == 0 }),
+				LazyBatchMap[int, int](4, func(b []int) ([]int, error) {
+					out := make([]int, len(b))
+					for j, v := range b {
+						out[j] = v + 1
+					}
+					return out, nil
+				}),
+			).
+			Run(WithWorkers(3), WithChunkSize(size))
+		assert.NoError(t, err)
+		assert.Equal(t, expected, result, "chunk size %d", size)
+	}
+}
+
+func TestChunkQueue_Steal(t *testing.T) {
+	// A single worker ends up with every chunk, each exactly once,
+	// by stealing from the others
+	q := newChunkQueue(sliceStream(make([]any, 95)), 10, 4)
+	var seen []int
+	for job, ok := q.next(context.Background(), 2); ok; job, ok = q.next(context.Background(), 2) {
+		seen = append(seen, job.seq)
+		assert.Equal(t, job.seq*10, job.lo)
+		assert.Equal(t, min(job.lo+10, 95), job.hi)
+	}
+	slices.Sort(seen)
+	assert.Equal(t, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, seen)
+}
+
+func TestChunkQueue_Concurrent(t *testing.T) {
+	q := newChunkQueue(sliceStream(make([]any, 10_000)), 7, 8)
+	var mu sync.Mutex
+	seen := make(map[int]int)
+	var wg sync.WaitGroup
+	for w := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job, ok := q.next(context.Background(), w); ok; job, ok = q.next(context.Background(), w) {
+				if w == 0 {
+					// A slow worker whose chunks are stolen by the others
+					time.Sleep(time.Millisecond)
+				}
+				mu.Lock()
+				seen[job.seq]++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Len(t, seen, (10_000+6)/7)
+	for c, n := range seen {
+		assert.Equal(t, 1, n, "chunk %d", c)
+	}
+}
+
+// --- Max In Flight Tests ---
+
+// inFlightProbe counts the elements started by a stage and consumed
+// downstream, and records the largest difference seen by the consumer.
+type inFlightProbe struct {
+	started atomic.Int64
+	peak    int64
+}
+
+func (p *inFlightProbe) stage(slow func(int) bool) ElemStage {
+	return LazyMap[int, int](func(i int) int {
+		p.started.Add(1)
+		if slow(i) {
+			time.Sleep(20 * time.Millisecond)
+		}
+		return i
+	})
+}
+
+func (p *inFlightProbe) consume(t *testing.T, seq iter.Seq2[int, error]) []int {
+	var out []int
+	for v, err := range seq {
+		assert.NoError(t, err)
+		out = append(out, v)
+		p.peak = max(p.peak, p.started.Load()-int64(len(out)))
+	}
+	return out
+}
+
+func TestLazyMaxInFlight_Ordered(t *testing.T) {
+	input := make([]int, 2_000)
+	for i := range input {
+		input[i] = i
+	}
+
+	// A slow element stalls the window instead of letting results pile up
+	var p inFlightProbe
+	lp := Lazy[int, int](input).Stage(p.stage(func(i int) bool { return i%500 == 0 }))
+	result := p.consume(t, lp.Seq(WithWorkers(4), WithMaxInFlight(16)))
+
+	assert.Equal(t, input, result)
+	assert.LessOrEqual(t, p.peak, int64(16))
+}
+
+func TestLazyMaxInFlight_Unordered(t *testing.T) {
+	input := make([]int, 2_000)
+	for i := range input {
+		input[i] = i
+	}
+
+	var p inFlightProbe
+	lp := Lazy[int, int](input).Stage(p.stage(func(i int) bool { return i%500 == 0 }))
+	result := p.consume(t, lp.Seq(WithWorkers(4), WithMaxInFlight(16), WithOrdered(false)))
+
+	assert.ElementsMatch(t, input, result)
+	assert.LessOrEqual(t, p.peak, int64(16))
+}
+
+func TestLazyMaxInFlight_Stream(t *testing.T) {
+	input := make([]int, 2_000)
+	for i := range input {
+		input[i] = i
+	}
+
+	var p inFlightProbe
+	lp := LazyFromSeq[int, int](slices.Values(input)).Stage(p.stage(func(i int) bool { return i == 3 }))
+	result := p.consume(t, lp.Seq(WithWorkers(4), WithMaxInFlight(10)))
+
+	assert.Equal(t, input, result)
+	assert.LessOrEqual(t, p.peak, int64(10))
+}
+
+func TestLazyMaxInFlight_Batching(t *testing.T) {
+	// Batches larger than the window are run partially
+	// rather than waiting for elements the window holds back
+	input := make([]int, 500)
+	expected := make([]int, len(input))
+	for i := range input {
+		input[i] = i
+		expected[i] = i * 2
+	}
+
+	lp := Lazy[int, int](input).Stage(LazyBatchMap[int, int](50, func(b []int) ([]int, error) {
+		out := make([]int, len(b))
+		for j, v := range b {
+			out[j] = v * 2
+		}
+		return out, nil
+	}))
+	for _, ordered := range []bool{true, false} {
+		result, err := lp.Run(WithWorkers(4), WithParallelThreshold(1), WithMaxInFlight(8), WithOrdered(ordered))
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, expected, result)
+		if ordered {
+			assert.Equal(t, expected, result)
+		}
+	}
+}
+
+func TestLazyMaxInFlight_Error(t *testing.T) {
+	input := make([]int, 1_000)
+	for i := range input {
+		input[i] = i
+	}
+
+	_, err := Lazy[int, int](input).
+		Elem(LazyMapWithError[int, int](func(i int) (int, error) {
+			if i == 700 {
+				return 0, errors.New("boom")
+			}
+			return i, nil
+		})).
+		Run(WithWorkers(4), WithMaxInFlight(4))
+
+	assert.EqualError(t, err, "stage 0, index 700: boom")
+}
+
+// --- Parallel Dispatch Benchmarks ---
+
+func benchInput(n int) []int {
+	input := make([]int, n)
+	for i := range input {
+		input[i] = i
+	}
+	return input
+}
+
+// spin burns CPU for roughly n iterations without sleeping.
+func spin(n int) int {
+	x := 0
+	for i := 0; i < n; i++ {
+		x += i ^ x
+	}
+	return x
+}
+
+func BenchmarkLazyMap_Sequential(b *testing.B) {
+	input := benchInput(100_000)
+	lp := Lazy[int, int](input).Elem(LazyMap[int, int](func(i int) int { return i * 2 }))
+	b.ReportAllocs()
+	for range b.N {
+		lp.Run()
+	}
+}
+
+func BenchmarkLazyMap_Parallel(b *testing.B) {
+	input := benchInput(100_000)
+	lp := Lazy[int, int](input).Elem(LazyMap[int, int](func(i int) int { return i * 2 }))
+	b.ReportAllocs()
+	for range b.N {
+		lp.Run(WithWorkers(4))
+	}
+}
+
+func BenchmarkLazyMap_ParallelUnordered(b *testing.B) {
+	input := benchInput(100_000)
+	lp := Lazy[int, int](input).Elem(LazyMap[int, int](func(i int) int { return i * 2 }))
+	b.ReportAllocs()
+	for range b.N {
+		lp.Run(WithWorkers(4), WithOrdered(false))
+	}
+}
+
+func BenchmarkLazyMap_ParallelSeq(b *testing.B) {
+	input := benchInput(100_000)
+	lp := LazyFromSeq[int, int](slices.Values(input)).Elem(LazyMap[int, int](func(i int) int { return i * 2 }))
+	b.ReportAllocs()
+	for range b.N {
+		lp.Run(WithWorkers(4))
+	}
+}
+
+func BenchmarkLazyMap_ParallelSkewed(b *testing.B) {
+	// The first elements are far more expensive than the rest, so that
+	// a static split would leave most workers idle
+	input := benchInput(20_000)
+	lp := Lazy[int, int](input).Elem(LazyMap[int, int](func(i int) int {
+		if i < 2_000 {
+			return spin(2_000)
+		}
+		return spin(10)
+	}))
+	b.ReportAllocs()
+	for range b.N {
+		lp.Run(WithWorkers(4))
+	}
+}
@@ -0,0 +1,201 @@
+package functional
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+)
+
+// withTimeout returns a context that expires after d with a *TimeoutError
+// as its cause.
+func withTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	return context.WithTimeoutCause(ctx, d, &TimeoutError{Timeout: d, Stage: -1, Index: -1})
+}
+
+// ctxErr returns the *TimeoutError that expired ctx, if any, or ctx.Err().
+func ctxErr(ctx context.Context) error {
+	err := ctx.Err()
+	if err == nil {
+		return nil
+	}
+	var te *TimeoutError
+	if errors.As(context.Cause(ctx), &te) {
+		return te
+	}
+	return err
+}
+
+// await runs fn on its own goroutine and waits for it, or for ctx to be done.
+// In the latter case fn is abandoned: it keeps running in the background and
+// its result is discarded. A panic in fn is re-raised on the caller's
+// goroutine, unless fn was abandoned.
+func await[T any](ctx context.Context, fn func() T) (T, error) {
+	type result struct {
+		v     T
+		panic any
+	}
+	done := make(chan result, 1)
+	go func() {
+		var r result
+		defer func() {
+			r.panic = recover()
+			done <- r
+		}()
+		r.v = fn()
+	}()
+
+	select {
+	case r := <-done:
+		if r.panic != nil {
+			panic(r.panic)
+		}
+		return r.v, nil
+	case <-ctx.Done():
+		var zero T
+		return zero, ctxErr(ctx)
+	}
+}
+
+// timeoutSpec makes the function of s give up after d, or at the deadline
+// already set on its context when d is 0. Order-sensitive stages cannot
+// time out, since an abandoned call could still update their state; s
+// records that as its error.
+func timeoutSpec(s elemSpec, d time.Duration) elemSpec {
+	switch {
+	case s.serial != nil:
+		s.err = errors.New("LazyTimeout: order-sensitive stages cannot time out")
+	case s.fn != nil:
+		s.fn = timeoutElem(s.fn, d)
+	case s.flat != nil:
+		s.flat = timeoutFlat(s.flat, d)
+	case s.batch != nil:
+		s.batch = timeoutBatch(s.batch, d)
+	}
+	return s
+}
+
+func timeoutElem(fn ElemFnCtx, d time.Duration) ElemFnCtx {
+	type result struct {
+		out  any
+		keep bool
+		err  error
+	}
+	return func(ctx context.Context, elem any) (any, bool, error) {
+		if d > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = withTimeout(ctx, d)
+			defer cancel()
+		}
+		r, err := await(ctx, func() result {
+			out, keep, err := fn(ctx, elem)
+			return result{out, keep, err}
+		})
+		if err == nil {
+			err = r.err
+		}
+		if err != nil {
+			return nil, false, timeoutOr(ctx, err)
+		}
+		return r.out, r.keep, nil
+	}
+}
+
+// timeoutFlat is timeoutElem for one-to-many stages. The outputs are
+// collected on the goroutine running fn and handed on once it returns, so
+// that the time spent downstream does not count against the deadline: fn
+// must produce all of them in time.
+func timeoutFlat(fn flatFn, d time.Duration) flatFn {
+	type result struct {
+		outs []any
+		err  error
+	}
+	return func(ctx context.Context, elem any, yield func(any) bool) error {
+		if d > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = withTimeout(ctx, d)
+			defer cancel()
+		}
+		r, err := await(ctx, func() result {
+			var outs []any
+			cut := false
+			err := fn(ctx, elem, func(v any) bool {
+				if ctx.Err() != nil {
+					// Abandoned: stop an endless expansion too
+					cut = true
+					return false
+				}
+				outs = append(outs, v)
+				return true
+			})
+			if err == nil && cut {
+				err = ctx.Err()
+			}
+			return result{outs, err}
+		})
+		if err == nil {
+			err = r.err
+		}
+		if err != nil {
+			return timeoutOr(ctx, err)
+		}
+		for _, v := range r.outs {
+			if !yield(v) {
+				break
+			}
+		}
+		return nil
+	}
+}
+
+func timeoutBatch(fn *batchFn, d time.Duration) *batchFn {
+	type result struct {
+		outs []any
+		err  error
+	}
+	return &batchFn{size: fn.size, run: func(ctx context.Context, elems []any) ([]any, error) {
+		if d > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = withTimeout(ctx, d)
+			defer cancel()
+		}
+		r, err := await(ctx, func() result {
+			outs, err := fn.run(ctx, elems)
+			return result{outs, err}
+		})
+		if err == nil {
+			err = r.err
+		}
+		if err != nil {
+			return nil, timeoutOr(ctx, err)
+		}
+		return r.outs, nil
+	}}
+}
+
+// timeoutOr reports a context-aware function failing because its deadline
+// passed as the *TimeoutError, and any other error as is.
+func timeoutOr(ctx context.Context, err error) error {
+	var te *TimeoutError
+	if errors.As(ctxErr(ctx), &te) && errors.Is(err, context.DeadlineExceeded) {
+		return te
+	}
+	return err
+}
+
+// timed returns a copy of seg whose stage functions give up at the element
+// deadline set by apply, for WithElementTimeout. The shared segment is not
+// modified.
+func (seg segment) timed() segment {
+	if seg.barrierFn != nil || seg.serial {
+		return seg
+	}
+	seg.elemFns = slices.Clone(seg.elemFns)
+	seg.flatFns = slices.Clone(seg.flatFns)
+	seg.batchFns = slices.Clone(seg.batchFns)
+	for i := range seg.elemFns {
+		s := timeoutSpec(elemSpec{fn: seg.elemFns[i], flat: seg.flatFns[i], batch: seg.batchFns[i]}, 0)
+		seg.elemFns[i], seg.flatFns[i], seg.batchFns[i] = s.fn, s.flat, s.batch
+	}
+	return seg
+}
@@ -1,9 +1,16 @@
 package functional
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+)
 
 type PipeFn func(any /* []In */) (any /* []Out */, error)
 
+// PipeFnCtx is a PipeFn that also receives the pipeline context.
+// Wrap it with BarrierCtx for use in a LazyPipeline.
+type PipeFnCtx func(ctx context.Context, input any /* []In */) (any /* []Out */, error)
+
 func Map[In, Out any](fn func(In) Out) PipeFn {
 	return func(input any /* []In */) (any /* []Out */, error) {
 		slice, ok := input.([]In)
@@ -30,7 +37,15 @@ func MapWithError[In, Out any](fn func(In) (Out, error)) PipeFn {
 		if !ok {
 			return nil, fmt.Errorf("MapWithError: type assertion failed: expected []%T, got %T", *new(In), input)
 		}
-		return SliceMapWithError(slice, fn)
+		out := make([]Out, 0, len(slice))
+		for i, v := range slice {
+			o, err := fn(v)
+			if err != nil {
+				return nil, &StageError{StageIndex: -1, Index: i, Err: err}
+			}
+			out = append(out, o)
+		}
+		return out, nil
 	}
 }
 
@@ -84,9 +99,9 @@ func TapWithError[T any](fn func(T) error) PipeFn {
 		if !ok {
 			return nil, fmt.Errorf("TapWithError: type assertion failed: expected []%T, got %T", *new(T), input)
 		}
-		for _, v := range slice {
+		for i, v := range slice {
 			if err := fn(v); err != nil {
-				return nil, err
+				return nil, &StageError{StageIndex: -1, Index: i, Err: err}
 			}
 		}
 		return slice, nil
@@ -119,6 +134,59 @@ func OnceWith[T any](fn func([]T) error) PipeFn {
 	}
 }
 
+// NamedPipe gives a PipeFn a name, reported in the StageError returned
+// by Pipe when fn fails.
+func NamedPipe(name string, fn PipeFn) PipeFn {
+	return func(input any) (any, error) {
+		out, err := fn(input)
+		if err != nil {
+			return nil, stageError(err, name, -1, -1)
+		}
+		return out, nil
+	}
+}
+
+// Retry returns a PipeFn that re-invokes fn when it returns an error,
+// following policy. Each attempt receives the same input slice.
+// Nothing cancels the sleep between attempts: in a LazyPipeline, use
+// BarrierCtx with RetryCtx so that it honors WithContext.
+func Retry(fn PipeFn, policy RetryPolicy) PipeFn {
+	retry := RetryCtx(fn, policy)
+	return func(input any) (any, error) {
+		return retry(context.Background(), input)
+	}
+}
+
+// RetryCtx is Retry aborting the sleep between attempts when ctx is
+// cancelled, e.g. in BarrierCtx[In, Out](RetryCtx(fn, policy)).
+func RetryCtx(fn PipeFn, policy RetryPolicy) PipeFnCtx {
+	return func(ctx context.Context, input any) (any, error) {
+		var out any
+		err := policy.do(ctx, func() error {
+			var err error
+			out, err = fn(input)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		return out, nil
+	}
+}
+
+// Recover returns a PipeFn that turns a panic in fn into a *PanicError,
+// which Pipe reports as the error of the stage.
+func Recover(fn PipeFn) PipeFn {
+	return func(input any) (out any, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				out, err = nil, recovered(r)
+			}
+		}()
+		return fn(input)
+	}
+}
+
 // example
 // functional.Pipe[int, string](
 //   []int{1, 2, 3},
@@ -126,18 +194,21 @@ func OnceWith[T any](fn func([]T) error) PipeFn {
 //   functional.Map(func(i int) string { return strconv.Itoa(i * 10) }),
 //   functional.MapWithError(func(s string) (string, error) { return s + "!", nil }),
 // ) // return []string{"20!", "30!"}, nil
+//
+// Errors are returned as *StageError, recording the failing stage and,
+// for MapWithError and TapWithError, the failing element.
 func Pipe[In, Out any](input []In, fns ...PipeFn) ([]Out, error) {
 	var current any = input
-	for _, fn := range fns {
+	for i, fn := range fns {
 		result, err := fn(current)
 		if err != nil {
-			return nil, err
+			return nil, stageError(err, "", i, -1)
 		}
 		current = result
 	}
-	result, ok := current.([]Out)
-	if !ok {
-		return nil, fmt.Errorf("Pipe: type assertion failed: expected []%T, got %T", *new(Out), current)
+	result, err := as[[]Out](current)
+	if err != nil {
+		return nil, err
 	}
 	return result, nil
 }
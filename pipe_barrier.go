@@ -0,0 +1,144 @@
+package functional
+
+import (
+	"container/heap"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+)
+
+// SliceFn is a typed slice-level transformation from []In to []Out, as
+// returned by the built-in barriers SortBy, GroupBy, Chunk, Reverse, TopK
+// and Shuffle. It can be passed directly to LazyPipeline.Pipe, recording In
+// and Out for Validate, and to Pipe through its PipeFn method.
+type SliceFn[In, Out any] struct {
+	fn func([]In) ([]Out, error)
+}
+
+// PipeFn returns fn as a PipeFn, for use with Pipe or Barrier.
+func (fn SliceFn[In, Out]) PipeFn() PipeFn {
+	return func(input any /* []In */) (any /* []Out */, error) {
+		slice, err := as[[]In](input)
+		if err != nil {
+			return nil, err
+		}
+		return fn.fn(slice)
+	}
+}
+
+// barrier lets fn be used in LazyPipeline.Pipe without Barrier.
+func (fn SliceFn[In, Out]) barrier() BarrierFn {
+	return Barrier[In, Out](fn.PipeFn())
+}
+
+// SortBy returns a SliceFn that sorts the slice with cmp, keeping the order
+// of equal elements. cmp follows slices.SortStableFunc. The input slice is
+// not modified.
+func SortBy[T any](cmp func(a, b T) int) SliceFn[T, T] {
+	return SliceFn[T, T]{fn: func(slice []T) ([]T, error) {
+		out := slices.Clone(slice)
+		slices.SortStableFunc(out, cmp)
+		return out, nil
+	}}
+}
+
+// GroupBy returns a SliceFn that groups the elements of a []V by key into a
+// []Pair[K, []V]. Groups are in the order their keys first appear and keep
+// the order of their elements.
+func GroupBy[V any, K comparable](key func(V) K) SliceFn[V, Pair[K, []V]] {
+	return SliceFn[V, Pair[K, []V]]{fn: func(slice []V) ([]Pair[K, []V], error) {
+		var groups []Pair[K, []V]
+		index := make(map[K]int)
+		for _, v := range slice {
+			k := key(v)
+			i, ok := index[k]
+			if !ok {
+				i = len(groups)
+				index[k] = i
+				groups = append(groups, Pair[K, []V]{Key: k})
+			}
+			groups[i].Value = append(groups[i].Value, v)
+		}
+		return groups, nil
+	}}
+}
+
+// Chunk returns a SliceFn that splits a []T into a [][]T of consecutive
+// chunks of n elements. The last chunk may be shorter.
+func Chunk[T any](n int) SliceFn[T, []T] {
+	return SliceFn[T, []T]{fn: func(slice []T) ([][]T, error) {
+		if n <= 0 {
+			return nil, fmt.Errorf("Chunk: size must be positive, got %d", n)
+		}
+		chunks := make([][]T, 0, (len(slice)+n-1)/n)
+		for chunk := range slices.Chunk(slice, n) {
+			chunks = append(chunks, chunk)
+		}
+		return chunks, nil
+	}}
+}
+
+// Reverse returns a SliceFn that reverses the order of the elements.
+// The input slice is not modified.
+func Reverse[T any]() SliceFn[T, T] {
+	return SliceFn[T, T]{fn: func(slice []T) ([]T, error) {
+		out := slices.Clone(slice)
+		slices.Reverse(out)
+		return out, nil
+	}}
+}
+
+// TopK returns a SliceFn that keeps the k greatest elements according to
+// less, from greatest to smallest. It keeps a heap of k elements instead
+// of sorting the whole slice.
+func TopK[T any](k int, less func(a, b T) bool) SliceFn[T, T] {
+	return SliceFn[T, T]{fn: func(slice []T) ([]T, error) {
+		if k <= 0 {
+			return []T{}, nil
+		}
+		h := &minHeap[T]{less: less}
+		for _, v := range slice {
+			if len(h.items) < k {
+				heap.Push(h, v)
+			} else if less(h.items[0], v) {
+				h.items[0] = v
+				heap.Fix(h, 0)
+			}
+		}
+		out := make([]T, len(h.items))
+		for i := len(out) - 1; i >= 0; i-- {
+			out[i] = heap.Pop(h).(T)
+		}
+		return out, nil
+	}}
+}
+
+// Shuffle returns a SliceFn that shuffles the elements. The same seed
+// always gives the same order for the same input. The input slice is not
+// modified.
+func Shuffle[T any](seed uint64) SliceFn[T, T] {
+	return SliceFn[T, T]{fn: func(slice []T) ([]T, error) {
+		out := slices.Clone(slice)
+		r := rand.New(rand.NewPCG(seed, seed))
+		r.Shuffle(len(out), func(i, j int) {
+			out[i], out[j] = out[j], out[i]
+		})
+		return out, nil
+	}}
+}
+
+// minHeap implements heap.Interface with the smallest element on top.
+type minHeap[T any] struct {
+	items []T
+	less  func(a, b T) bool
+}
+
+func (h *minHeap[T]) Len() int           { return len(h.items) }
+func (h *minHeap[T]) Less(i, j int) bool { return h.less(h.items[i], h.items[j]) }
+func (h *minHeap[T]) Swap(i, j int)      { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *minHeap[T]) Push(x any)         { h.items = append(h.items, x.(T)) }
+func (h *minHeap[T]) Pop() any {
+	last := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return last
+}
@@ -1,9 +1,11 @@
 package functional
 
 import (
+	"context"
 	"fmt"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -65,7 +67,7 @@ func TestPipeTypeAssertionError(t *testing.T) {
 		Map(func(i int) int { return i * 2 }),
 	)
 
-	assert.Error(t, err)
+	assert.Equal(t, &TypeMismatchError{Expected: "[]string", Actual: "[]int", Stage: -1, Index: -1}, err)
 }
 
 func TestPipeChainedMaps(t *testing.T) {
@@ -344,3 +346,164 @@ func TestPipeOnceWith_TypeAssertionError(t *testing.T) {
 
 	assert.Error(t, err)
 }
+
+func TestRetry(t *testing.T) {
+	attempts := 0
+	result, err := Pipe[int, int](
+		[]int{1, 2, 3},
+		Retry(MapWithError(func(i int) (int, error) {
+			if i == 3 {
+				attempts++
+				if attempts < 2 {
+					return 0, fmt.Errorf("transient")
+				}
+			}
+			return i * 2, nil
+		}), RetryPolicy{MaxAttempts: 2}),
+	)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []int{2, 4, 6}, result)
+	assert.Equal(t, 2, attempts)
+}
+
+func TestRetry_Exhausted(t *testing.T) {
+	attempts := 0
+	_, err := Pipe[int, int](
+		[]int{1},
+		Retry(MapWithError(func(i int) (int, error) {
+			attempts++
+			return 0, fmt.Errorf("attempt %d", attempts)
+		}), RetryPolicy{MaxAttempts: 2}),
+	)
+
+	assert.EqualError(t, err, "stage 0, index 0: attempt 2")
+}
+
+func TestRetryCtx_CancelledDuringBackoff(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	attempts := 0
+	retry := RetryCtx(func(input any) (any, error) {
+		attempts++
+		cancel()
+		return nil, fmt.Errorf("transient")
+	}, RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Hour})
+
+	_, err := retry(ctx, []int{1})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 1, attempts)
+}
+
+func TestPipeStageError(t *testing.T) {
+	_, err := Pipe[string, int](
+		[]string{"1", "2", "x"},
+		Filter(func(s string) bool { return s != "" }),
+		NamedPipe("parse", MapWithError(strconv.Atoi)),
+	)
+
+	var se *StageError
+	assert.ErrorAs(t, err, &se)
+	assert.Equal(t, "parse", se.Stage)
+	assert.Equal(t, 1, se.StageIndex)
+	assert.Equal(t, 2, se.Index)
+	assert.EqualError(t, err, `stage 1 (parse), index 2: strconv.Atoi: parsing "x": invalid syntax`)
+
+	var numErr *strconv.NumError
+	assert.ErrorAs(t, err, &numErr)
+}
+
+func TestPipeStageError_SliceLevel(t *testing.T) {
+	_, err := Pipe[int, int](
+		[]int{1},
+		Map(func(i int) int { return i }),
+		Once(func() error { return fmt.Errorf("once error") }),
+	)
+
+	assert.EqualError(t, err, "stage 1: once error")
+}
+
+func TestSortBy(t *testing.T) {
+	input := []string{"bb", "a", "cc", "d"}
+	result, err := Pipe[string, string](
+		input,
+		SortBy(func(a, b string) int { return len(a) - len(b) }).PipeFn(),
+	)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "d", "bb", "cc"}, result)
+	assert.Equal(t, []string{"bb", "a", "cc", "d"}, input)
+}
+
+func TestGroupBy(t *testing.T) {
+	result, err := Pipe[int, Pair[bool, []int]](
+		[]int{1, 2, 3, 4, 5},
+		GroupBy(func(i int) bool { return i%2 == 0 }).PipeFn(),
+	)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []Pair[bool, []int]{
+		{Key: false, Value: []int{1, 3, 5}},
+		{Key: true, Value: []int{2, 4}},
+	}, result)
+}
+
+func TestChunk(t *testing.T) {
+	result, err := Pipe[int, []int]([]int{1, 2, 3, 4, 5}, Chunk[int](2).PipeFn())
+	assert.NoError(t, err)
+	assert.Equal(t, [][]int{{1, 2}, {3, 4}, {5}}, result)
+
+	_, err = Pipe[int, []int]([]int{1}, Chunk[int](0).PipeFn())
+	assert.EqualError(t, err, "stage 0: Chunk: size must be positive, got 0")
+}
+
+func TestReverse(t *testing.T) {
+	result, err := Pipe[int, int]([]int{1, 2, 3}, Reverse[int]().PipeFn())
+	assert.NoError(t, err)
+	assert.Equal(t, []int{3, 2, 1}, result)
+}
+
+func TestTopK(t *testing.T) {
+	less := func(a, b int) bool { return a < b }
+
+	result, err := Pipe[int, int]([]int{5, 1, 9, 3, 7, 2}, TopK(3, less).PipeFn())
+	assert.NoError(t, err)
+	assert.Equal(t, []int{9, 7, 5}, result)
+
+	result, err = Pipe[int, int]([]int{2, 1}, TopK(5, less).PipeFn())
+	assert.NoError(t, err)
+	assert.Equal(t, []int{2, 1}, result)
+}
+
+func TestShuffle(t *testing.T) {
+	input := []int{1, 2, 3, 4, 5, 6, 7, 8}
+	a, err := Pipe[int, int](input, Shuffle[int](42).PipeFn())
+	assert.NoError(t, err)
+	b, err := Pipe[int, int](input, Shuffle[int](42).PipeFn())
+	assert.NoError(t, err)
+
+	assert.Equal(t, a, b)
+	assert.ElementsMatch(t, input, a)
+	assert.Equal(t, []int{1, 2, 3, 4, 5, 6, 7, 8}, input)
+}
+
+func TestBuiltinBarrier_TypeMismatch(t *testing.T) {
+	_, err := Pipe[int, int]([]int{1}, Reverse[string]().PipeFn())
+	var tm *TypeMismatchError
+	assert.ErrorAs(t, err, &tm)
+	assert.Equal(t, &TypeMismatchError{Expected: "[]string", Actual: "[]int", Stage: 0, Index: -1}, tm)
+	assert.EqualError(t, err, "stage 0: type mismatch: expected []string, got []int")
+}
+
+func TestRecover(t *testing.T) {
+	_, err := Pipe[int, int](
+		[]int{1, 2},
+		Map(func(i int) int { return i }),
+		Recover(Map(func(i int) int { panic("boom") })),
+	)
+
+	assert.EqualError(t, err, "stage 1: panic: boom")
+	var pe *PanicError
+	assert.ErrorAs(t, err, &pe)
+	assert.Equal(t, 1, pe.Stage)
+	assert.NotEmpty(t, pe.Stack)
+}
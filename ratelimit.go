@@ -0,0 +1,51 @@
+package functional
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket holding up to burst tokens and earning one
+// every interval. It is implemented as a generic cell rate algorithm: tat
+// is the time at which the bucket will be full again.
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	burst    int
+	tat      time.Time
+}
+
+func newRateLimiter(rps float64, burst int) *rateLimiter {
+	return &rateLimiter{
+		interval: time.Duration(float64(time.Second) / rps),
+		burst:    max(burst, 1),
+	}
+}
+
+// wait takes a token, sleeping until one is available.
+// If ctx is cancelled while waiting, ctx.Err() is returned and the token
+// is lost.
+func (l *rateLimiter) wait(ctx context.Context) error {
+	l.mu.Lock()
+	now := time.Now()
+	t := l.tat
+	if t.Before(now) {
+		t = now
+	}
+	delay := t.Sub(now) - time.Duration(l.burst-1)*l.interval
+	l.tat = t.Add(l.interval)
+	l.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		timer.Stop()
+		return ctx.Err()
+	}
+}
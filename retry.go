@@ -0,0 +1,72 @@
+package functional
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+)
+
+// RetryPolicy configures how LazyRetry and Retry re-invoke a failing function.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of calls, including the first one.
+	// Values below 1 are treated as 1 (no retry).
+	MaxAttempts int
+	// InitialBackoff is the delay before the second attempt.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between attempts. 0 means no cap.
+	MaxBackoff time.Duration
+	// Multiplier grows the delay after each attempt. 0 means 2.
+	Multiplier float64
+	// Jitter randomizes each delay down by up to this fraction (0 to 1),
+	// so that concurrent retries do not fire in lockstep.
+	Jitter float64
+	// Retryable reports whether err should be retried.
+	// nil means every error is retried.
+	Retryable func(err error) bool
+}
+
+// backoff returns the delay after the given attempt (1-based).
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier <= 0 {
+		multiplier = 2
+	}
+	d := float64(p.InitialBackoff)
+	for i := 1; i < attempt; i++ {
+		d *= multiplier
+		if p.MaxBackoff > 0 && d >= float64(p.MaxBackoff) {
+			break
+		}
+	}
+	if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		d -= d * min(p.Jitter, 1) * rand.Float64()
+	}
+	return time.Duration(d)
+}
+
+// do calls fn until it succeeds, returns a non-retryable error or
+// MaxAttempts is reached, returning the last error.
+// If ctx is cancelled while waiting, ctx.Err() is returned.
+func (p RetryPolicy) do(ctx context.Context, fn func() error) error {
+	attempts := max(p.MaxAttempts, 1)
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		if attempt >= attempts || (p.Retryable != nil && !p.Retryable(err)) {
+			return err
+		}
+
+		timer := time.NewTimer(p.backoff(attempt))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
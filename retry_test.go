@@ -0,0 +1,35 @@
+package functional
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	p := RetryPolicy{InitialBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+
+	assert.Equal(t, 10*time.Millisecond, p.backoff(1))
+	assert.Equal(t, 20*time.Millisecond, p.backoff(2))
+	assert.Equal(t, 40*time.Millisecond, p.backoff(3))
+	assert.Equal(t, 50*time.Millisecond, p.backoff(4))
+	assert.Equal(t, 50*time.Millisecond, p.backoff(10))
+}
+
+func TestRetryPolicy_BackoffMultiplier(t *testing.T) {
+	p := RetryPolicy{InitialBackoff: time.Millisecond, Multiplier: 3}
+
+	assert.Equal(t, 3*time.Millisecond, p.backoff(2))
+	assert.Equal(t, 9*time.Millisecond, p.backoff(3))
+}
+
+func TestRetryPolicy_BackoffJitter(t *testing.T) {
+	p := RetryPolicy{InitialBackoff: 100 * time.Millisecond, Jitter: 0.5}
+
+	for i := 0; i < 100; i++ {
+		d := p.backoff(1)
+		assert.GreaterOrEqual(t, d, 50*time.Millisecond)
+		assert.LessOrEqual(t, d, 100*time.Millisecond)
+	}
+}